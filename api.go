@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"mtd-system/mtd"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// controlAPI lets operators inspect and steer a running controller over HTTP.
+// Every request must carry the bearer token.
+type controlAPI struct {
+	controller *controller
+	token      string
+	// ctx bounds the movements requested through the API, which outlive their HTTP request
+	ctx context.Context
+}
+
+// newControlAPI returns the handler of the control API
+func newControlAPI(ctx context.Context, c *controller, token string) http.Handler {
+	api := &controlAPI{controller: c, token: token, ctx: ctx}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", api.only(http.MethodGet, api.status))
+	mux.HandleFunc("/decisions", api.only(http.MethodGet, api.decisions))
+	mux.HandleFunc("/move", api.only(http.MethodPost, api.move))
+	mux.HandleFunc("/pause", api.only(http.MethodPost, api.pause(true)))
+	mux.HandleFunc("/resume", api.only(http.MethodPost, api.pause(false)))
+	return api.authenticate(mux)
+}
+
+// authenticate rejects requests without the bearer token
+func (api *controlAPI) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mtd"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// only rejects requests with another method
+func (api *controlAPI) only(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// status reports the live variant, the last movement and the next scheduled one
+func (api *controlAPI) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, api.controller.status())
+}
+
+// decisions lists the last movements, newest first, at most ?limit of them
+func (api *controlAPI) decisions(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("limit must be a positive number"))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, api.controller.decisions(limit))
+}
+
+// move applies a movement right away. An optional JSON body with ip, port, os, format and language
+// forces the target, its missing fields keep their live value; without it the strategy decides.
+func (api *controlAPI) move(w http.ResponseWriter, r *http.Request) {
+	var target *mtd.MovementDecision
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		target = &mtd.MovementDecision{}
+		if err := json.Unmarshal(body, target); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	record, err := api.controller.moveTo(api.ctx, target)
+	switch {
+	case errors.Is(err, errInvalidTarget):
+		writeError(w, http.StatusBadRequest, err)
+	case err != nil && record.Timestamp.IsZero():
+		writeError(w, http.StatusInternalServerError, err)
+	case err != nil:
+		// The movement was recorded, report it along with the failure
+		writeJSON(w, http.StatusBadGateway, record)
+	default:
+		writeJSON(w, http.StatusOK, record)
+	}
+}
+
+// pause pauses or resumes the scheduled movements; movements requested through /move still apply
+func (api *controlAPI) pause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		api.controller.setPaused(paused)
+		writeJSON(w, http.StatusOK, api.controller.status())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
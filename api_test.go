@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"mtd-system/mtd"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testToken = "s3cret"
+
+// recordingActuator deploys nothing and records the decisions it is asked to apply and the move spaces it is given
+type recordingActuator struct {
+	mu      sync.Mutex
+	applied []mtd.MovementDecision
+	spaces  []mtd.Config
+}
+
+func (a *recordingActuator) Apply(ctx context.Context, decision mtd.MovementDecision) (mtd.MovementDecision, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.applied = append(a.applied, decision)
+	return decision, nil
+}
+
+func (a *recordingActuator) UseMoveSpace(space mtd.Config) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.spaces = append(a.spaces, space)
+}
+
+func (a *recordingActuator) moveSpaces() []mtd.Config {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]mtd.Config(nil), a.spaces...)
+}
+
+func (a *recordingActuator) decisions() []mtd.MovementDecision {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]mtd.MovementDecision(nil), a.applied...)
+}
+
+// noMetrics is a metrics source returning empty metrics
+type noMetrics struct{}
+
+func (noMetrics) Collect(ctx context.Context) (mtd.Metrics, error) {
+	return mtd.Metrics{}, nil
+}
+
+func testMoveSpace() mtd.Config {
+	return mtd.Config{
+		Ports:     []string{"8080", "8081", "8082"},
+		OSes:      []string{"ubuntu", "alpine"},
+		Formats:   []string{"json", "xml"},
+		Languages: []string{"go", "python"},
+	}
+}
+
+// newTestController returns a round-robin controller applying its movements to the returned actuator
+func newTestController(t *testing.T) (*controller, *recordingActuator) {
+	t.Helper()
+	actuator := &recordingActuator{}
+	return &controller{
+		actuator: actuator,
+		metrics:  noMetrics{},
+		strategy: mtd.NewRoundRobinStrategy(),
+		config:   testMoveSpace(),
+		policy:   StrategyConfig{Name: mtd.RoundRobin},
+	}, actuator
+}
+
+// request sends a request to the control API with the test token and returns the recorded response
+func request(api http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestControlAPIAuthentication(t *testing.T) {
+	c, actuator := newTestController(t)
+	api := newControlAPI(context.Background(), c, testToken)
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{name: "missing token"},
+		{name: "wrong token", authorization: "Bearer wrong"},
+		{name: "token prefix", authorization: "Bearer " + testToken[:3]},
+		{name: "other scheme", authorization: "Basic " + testToken},
+		{name: "bare token", authorization: testToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"/status", "/move", "/unknown"} {
+				req := httptest.NewRequest(http.MethodPost, path, nil)
+				if tt.authorization != "" {
+					req.Header.Set("Authorization", tt.authorization)
+				}
+				rec := httptest.NewRecorder()
+				api.ServeHTTP(rec, req)
+				if rec.Code != http.StatusUnauthorized {
+					t.Errorf("%s status = %d, want %d", path, rec.Code, http.StatusUnauthorized)
+				}
+				if rec.Header().Get("WWW-Authenticate") == "" {
+					t.Errorf("%s answered without a WWW-Authenticate header", path)
+				}
+			}
+		})
+	}
+	if applied := actuator.decisions(); len(applied) != 0 {
+		t.Errorf("unauthenticated requests applied %v", applied)
+	}
+
+	if rec := request(api, http.MethodGet, "/status", ""); rec.Code != http.StatusOK {
+		t.Errorf("authenticated /status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestControlAPIMoveInvalidTarget(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "unknown os", body: `{"os": "windows"}`},
+		{name: "unknown port", body: `{"port": "9999", "os": "alpine"}`},
+		{name: "unknown language", body: `{"language": "rust"}`},
+		{name: "unknown format", body: `{"format": "yaml"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, actuator := newTestController(t)
+			api := newControlAPI(context.Background(), c, testToken)
+
+			rec := request(api, http.MethodPost, "/move", tt.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), errInvalidTarget.Error()) {
+				t.Errorf("body = %s, want the %q error", rec.Body, errInvalidTarget)
+			}
+			if applied := actuator.decisions(); len(applied) != 0 {
+				t.Errorf("applied %v", applied)
+			}
+		})
+	}
+}
+
+func TestControlAPIMoveWhilePaused(t *testing.T) {
+	c, actuator := newTestController(t)
+	api := newControlAPI(context.Background(), c, testToken)
+
+	if rec := request(api, http.MethodPost, "/pause", ""); rec.Code != http.StatusOK {
+		t.Fatalf("/pause status = %d", rec.Code)
+	}
+
+	rec := request(api, http.MethodPost, "/move", `{"os": "alpine", "language": "python"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/move status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var record mtd.AuditRecord
+	if err := json.Unmarshal(rec.Body.Bytes(), &record); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+	if record.Actuation != mtd.ActuationApplied {
+		t.Errorf("actuation = %q, want %q", record.Actuation, mtd.ActuationApplied)
+	}
+
+	applied := actuator.decisions()
+	if len(applied) != 1 {
+		t.Fatalf("applied %d movements, want 1", len(applied))
+	}
+	if got := applied[0]; got.OS != "alpine" || got.Language != "python" || got.Strategy != mtd.Manual {
+		t.Errorf("applied %+v, want the forced alpine python movement", got)
+	}
+
+	status := c.status()
+	if !status.Paused {
+		t.Error("moving resumed the controller")
+	}
+	if status.Variant != mtd.VariantName(applied[0]) {
+		t.Errorf("live variant = %q, want %q", status.Variant, mtd.VariantName(applied[0]))
+	}
+}
+
+func TestControlAPIDecisions(t *testing.T) {
+	c, _ := newTestController(t)
+	api := newControlAPI(context.Background(), c, testToken)
+	for i := 0; i < 3; i++ {
+		if rec := request(api, http.MethodPost, "/move", ""); rec.Code != http.StatusOK {
+			t.Fatalf("/move status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string // Ports of the returned movements
+	}{
+		{query: "", want: []string{"8082", "8081", "8080"}},
+		{query: "?limit=0", want: []string{"8082", "8081", "8080"}},
+		{query: "?limit=2", want: []string{"8082", "8081"}},
+		{query: "?limit=1", want: []string{"8082"}},
+		{query: "?limit=10", want: []string{"8082", "8081", "8080"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := request(api, http.MethodGet, "/decisions"+tt.query, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			var records []mtd.AuditRecord
+			if err := json.Unmarshal(rec.Body.Bytes(), &records); err != nil {
+				t.Fatalf("decoding %s: %v", rec.Body, err)
+			}
+			var ports []string
+			for _, record := range records {
+				ports = append(ports, record.Decision.Port)
+			}
+			if strings.Join(ports, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("ports = %v, want %v, newest first", ports, tt.want)
+			}
+		})
+	}
+
+	for _, query := range []string{"?limit=-1", "?limit=two"} {
+		if rec := request(api, http.MethodGet, "/decisions"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("/decisions%s status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if rec := request(api, http.MethodPost, "/decisions", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /decisions status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
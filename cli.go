@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mtd-system/mtd"
+	"mtd-system/ollama"
+	"net/http"
+	"os"
+	"time"
+)
+
+// options holds the settings of the subcommands: the controller config, overridden by their flags
+type options struct {
+	configFile string
+	config     ControllerConfig
+	dryRun     bool
+}
+
+// reload loads the config file again and applies the flags given in args over it, as parse did
+func (o *options) reload(fs *flag.FlagSet, args []string) (ControllerConfig, error) {
+	config, err := LoadControllerConfig(o.configFile)
+	if err != nil {
+		return config, err
+	}
+	o.config = config
+	fs.Parse(args)
+	return o.config, nil
+}
+
+// parse loads the config file named by -config and applies the flags given in args over it.
+// The flags are parsed twice: first to find the config file, then again so they win over it.
+func (o *options) parse(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	config, err := LoadControllerConfig(o.configFile)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", o.configFile, err)
+	}
+	o.config = config
+	fs.Parse(args)
+	return nil
+}
+
+// durationVar registers a flag setting a config Duration
+func durationVar(fs *flag.FlagSet, d *Duration, name, usage string) {
+	fs.DurationVar((*time.Duration)(d), name, time.Duration(*d), usage)
+}
+
+// elasticFlags registers the Elasticsearch connection flags
+func (o *options) elasticFlags(fs *flag.FlagSet) {
+	c := &o.config
+	fs.StringVar(&c.Elasticsearch.URL, "es-url", c.Elasticsearch.URL, "Elasticsearch URL (ELASTICSEARCH_URL)")
+	fs.StringVar(&c.Elasticsearch.User, "es-user", c.Elasticsearch.User, "Elasticsearch user (ELASTICSEARCH_USER)")
+	fs.StringVar(&c.Elasticsearch.Password, "es-password", c.Elasticsearch.Password, "Elasticsearch password (ELASTICSEARCH_PASSWORD)")
+	fs.StringVar(&c.Knowledge.Index, "es-index", c.Knowledge.Index, "Elasticsearch index of the knowledge base (ELASTICSEARCH_INDEX)")
+}
+
+// configFlags registers the paths of the configuration files
+func (o *options) configFlags(fs *flag.FlagSet) {
+	o.config = DefaultControllerConfig()
+	c := &o.config
+	fs.StringVar(&o.configFile, "config", envOrDefault("MTD_CONFIG", "config/controller.json"), "controller config file, whose settings the other flags override (MTD_CONFIG)")
+	fs.StringVar(&c.Metrics.File, "metrics", c.Metrics.File, "metrics file (MTD_METRICS_FILE)")
+	fs.StringVar(&c.Knowledge.File, "knowledge-file", c.Knowledge.File, "knowledge.json used by the file knowledge base (KNOWLEDGE_DATA)")
+	fs.StringVar(&c.Metrics.PrometheusQueries, "prometheus-queries", c.Metrics.PrometheusQueries, "PromQL expressions of the prometheus metrics source")
+	fs.StringVar(&c.Metrics.ProbeLog, "probe-log", c.Metrics.ProbeLog, "client log the probes metrics source reads (MTD_PROBE_LOG)")
+}
+
+// decisionFlags registers the flags of everything a decision depends on
+func (o *options) decisionFlags(fs *flag.FlagSet) {
+	o.configFlags(fs)
+	o.elasticFlags(fs)
+	c := &o.config
+	fs.StringVar((*string)(&c.Strategy.Name), "strategy", string(c.Strategy.Name), fmt.Sprintf("movement strategy %v (MTD_STRATEGY)", mtd.StrategyTypes()))
+	fs.StringVar(&c.Knowledge.Backend, "knowledge", c.Knowledge.Backend, "knowledge base backend for the weighted strategy: elasticsearch or file (MTD_KNOWLEDGE)")
+	fs.StringVar(&c.Metrics.Source, "metrics-source", c.Metrics.Source, "where the metrics come from: file (the metrics file), probes (response time and error rate measured by the client) or prometheus (MTD_METRICS_SOURCE)")
+	durationVar(fs, &c.Metrics.ProbeWindow, "probe-window", "sliding window probe results are aggregated over")
+	fs.Float64Var(&c.Metrics.ProbePercentile, "probe-percentile", c.Metrics.ProbePercentile, "response time percentile reported by the probes metrics source")
+	fs.StringVar(&c.Metrics.PrometheusURL, "prometheus-url", c.Metrics.PrometheusURL, "Prometheus API the prometheus metrics source queries (PROMETHEUS_URL)")
+
+	fs.StringVar(&c.Advisor.URL, "ollama-url", c.Advisor.URL, "Ollama API endpoint (OLLAMA_URL)")
+	fs.StringVar(&c.Advisor.Model, "ollama-model", c.Advisor.Model, "Ollama model advising the weighted strategy (OLLAMA_MODEL)")
+	fs.Float64Var(&c.Advisor.Temperature, "ollama-temperature", c.Advisor.Temperature, "Ollama sampling temperature")
+	durationVar(fs, &c.Advisor.Timeout, "ollama-timeout", "timeout of a single Ollama request")
+	fs.IntVar(&c.Advisor.Retries, "ollama-retries", c.Advisor.Retries, "Ollama retries after a failed request")
+}
+
+// auditFlags registers the audit trail flags
+func (o *options) auditFlags(fs *flag.FlagSet) {
+	c := &o.config
+	fs.StringVar(&c.Audit.File, "audit-file", c.Audit.File, "JSON Lines file every movement is appended to, empty to disable it (AUDIT_FILE)")
+	fs.StringVar(&c.Audit.Index, "audit-index", c.Audit.Index, "Elasticsearch index every movement is also recorded in, empty to disable it (AUDIT_INDEX)")
+}
+
+// actuatorFlags registers the flags of how movements are deployed
+func (o *options) actuatorFlags(fs *flag.FlagSet) {
+	c := &o.config
+	fs.StringVar(&c.Actuator.Kind, "actuator", c.Actuator.Kind, "how movements are deployed: compose (stop then start) or bluegreen (zero downtime, run only) (MTD_ACTUATOR)")
+	fs.StringVar(&c.Actuator.ComposeFile, "compose-file", c.Actuator.ComposeFile, "docker compose file of the services (MTD_COMPOSE_FILE)")
+	durationVar(fs, &c.Actuator.Drain, "drain", "longest time the old bluegreen variant is given to finish its in-flight requests")
+	durationVar(fs, &c.Actuator.HealthTimeout, "health-timeout", "time a new bluegreen variant has to become healthy before it is rolled back")
+}
+
+// daemonFlags registers the flags of the long running controller
+func (o *options) daemonFlags(fs *flag.FlagSet) {
+	c := &o.config
+	durationVar(fs, &c.Schedule.Interval, "interval", "time between movements (MTD_INTERVAL)")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "print every decision and its reasoning as JSON instead of applying it")
+	fs.StringVar(&c.Server.ProxyListen, "proxy-listen", c.Server.ProxyListen, "address of the reverse proxy clients connect to, empty to disable it (MTD_PROXY_LISTEN)")
+	fs.StringVar(&c.Server.MetricsListen, "metrics-listen", c.Server.MetricsListen, "address serving the controller's own metrics on /metrics, empty to disable it (MTD_METRICS_LISTEN)")
+	fs.StringVar(&c.Server.APIListen, "api-listen", c.Server.APIListen, "address serving the control API, empty to disable it (MTD_API_LISTEN)")
+	fs.StringVar(&c.Server.APIToken, "api-token", c.Server.APIToken, "bearer token required by the control API (MTD_API_TOKEN)")
+	durationVar(fs, &c.Schedule.WatchInterval, "watch-interval", "how often the config file is checked for changes to reload, 0 to only reload on SIGHUP")
+	durationVar(fs, &c.Schedule.LearnWindow, "learn-window", "time the metrics are observed after a movement before learning it as a policy, 0 to disable learning")
+	fs.Float64Var(&c.Schedule.LearnMinScore, "learn-min-score", c.Schedule.LearnMinScore, "lowest outcome score, from 0 to 1, a movement needs to be learned")
+}
+
+// controllerMode tells newController what the controller is used for
+type controllerMode struct {
+	daemon bool // Keeps running, serving the proxy, metrics and control API
+	apply  bool // Deploys its decisions, otherwise prints them
+}
+
+// newController builds the controller from the options. The returned function releases its resources.
+func (o *options) newController(ctx context.Context, mode controllerMode) (*controller, func(), error) {
+	cleanup := func() {}
+	config := o.config
+	if err := config.Validate(); err != nil {
+		return nil, cleanup, fmt.Errorf("invalid config %s:\n%w", o.configFile, err)
+	}
+	if err := registerMetrics(config.Metrics.Definitions); err != nil {
+		return nil, cleanup, err
+	}
+
+	kind := config.Strategy.Name
+	deps := mtd.StrategyDependencies{Settings: config.Strategy.StrategySettings}
+
+	telemetry := newControllerMetrics()
+
+	// Only the weighted strategy needs the knowledge base and the advisor
+	var knowledge mtd.KnowledgeBase
+	var err error
+	if kind == mtd.Weighted {
+		knowledge, err = o.newKnowledgeBase()
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("initializing knowledge base: %w", err)
+		}
+		deps.Knowledge = instrumentedKnowledge{KnowledgeBase: knowledge, metrics: telemetry}
+
+		advisor, err := ollama.NewClient(config.Advisor.ollama())
+		if err != nil {
+			// Without an advisor the weighted strategy applies the best matching policy
+			log.Printf("Error initializing Ollama, continuing without advisor: %v", err)
+		} else {
+			deps.Advisor = instrumentAdvisor(advisor, telemetry)
+		}
+	}
+
+	strategy, err := mtd.NewStrategy(kind, deps)
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("creating strategy: %w", err)
+	}
+	log.Printf("Using %s strategy", kind)
+
+	source, err := o.newMetricsSource()
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("initializing metrics source: %w", err)
+	}
+
+	dryRun := !mode.apply || o.dryRun
+	c := &controller{
+		strategy:  strategy,
+		config:    config.MoveSpace,
+		policy:    config.Strategy,
+		deps:      deps,
+		metrics:   source,
+		dryRun:    dryRun,
+		out:       os.Stdout,
+		telemetry: telemetry,
+	}
+
+	var audit mtd.MultiAuditLog
+	if config.Audit.File != "" && mode.apply {
+		file, err := mtd.OpenFileAuditLog(config.Audit.File)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("initializing audit log: %w", err)
+		}
+		cleanup = func() { file.Close() }
+		audit = append(audit, file)
+	}
+	if config.Audit.Index != "" && mode.apply {
+		es, err := mtd.ConnectElasticsearch(config.Elasticsearch.settings())
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("initializing audit log: %w", err)
+		}
+		audit = append(audit, mtd.NewElasticAuditLog(es, config.Audit.Index))
+	}
+	if len(audit) > 0 {
+		c.audit = audit
+	}
+
+	// Learning only makes sense when movements are applied, and each outcome must be observed before the next movement
+	if config.Schedule.LearnWindow > 0 && mode.daemon && !dryRun {
+		writer, ok := knowledge.(mtd.KnowledgeWriter)
+		if !ok {
+			return nil, cleanup, errors.New("learning requires the weighted strategy and its knowledge base")
+		}
+		settings := mtd.LearnerSettings{Window: time.Duration(config.Schedule.LearnWindow), MinScore: config.Schedule.LearnMinScore}
+		c.learner, err = mtd.NewLearner(writer, source.Collect, settings)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("creating learner: %w", err)
+		}
+	}
+
+	if !dryRun {
+		proxyListen := ""
+		if mode.daemon {
+			proxyListen = config.Server.ProxyListen
+		}
+		actuator, err := newActuator(ctx, config.Actuator, config.MoveSpace, proxyListen)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		c.actuator = instrumentedActuator{Actuator: actuator, metrics: telemetry}
+		// The blue/green actuator stops the old variants it is still draining on shutdown
+		if closer, ok := actuator.(io.Closer); ok {
+			closeAudit := cleanup
+			cleanup = func() {
+				closer.Close()
+				closeAudit()
+			}
+		}
+	}
+
+	if mode.daemon && config.Server.MetricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", telemetry.registry)
+		go func() {
+			if err := serve(ctx, "Metrics", config.Server.MetricsListen, mux); err != nil {
+				log.Fatalf("Error serving metrics: %v", err)
+			}
+		}()
+	}
+
+	if mode.daemon && config.Server.APIListen != "" {
+		go func() {
+			if err := serve(ctx, "Control API", config.Server.APIListen, newControlAPI(ctx, c, config.Server.APIToken)); err != nil {
+				log.Fatalf("Error serving control API: %v", err)
+			}
+		}()
+	}
+
+	return c, cleanup, nil
+}
+
+// newKnowledgeBase creates the knowledge base of the selected backend
+func (o *options) newKnowledgeBase() (mtd.KnowledgeBase, error) {
+	knowledge := o.config.Knowledge
+	switch knowledge.Backend {
+	case "elasticsearch":
+		es, err := mtd.ConnectElasticsearch(o.config.Elasticsearch.settings())
+		if err != nil {
+			return nil, err
+		}
+		return mtd.NewElasticKnowledgeBase(es, knowledge.Index), nil
+	case "file":
+		return mtd.LoadMemoryKnowledgeBase(knowledge.File)
+	default:
+		return nil, fmt.Errorf("unknown knowledge backend %q, use elasticsearch or file", knowledge.Backend)
+	}
+}
+
+// newMetricsSource creates the source movements are decided on, on top of the metrics file
+func (o *options) newMetricsSource() (mtd.MetricsSource, error) {
+	settings := o.config.Metrics
+	base := mtd.FileMetricsSource(settings.File)
+	switch settings.Source {
+	case "file":
+		return base, nil
+	case "probes":
+		return mtd.NewProbeCollector(settings.ProbeLog, base, mtd.ProbeSettings{
+			Window:     time.Duration(settings.ProbeWindow),
+			Percentile: settings.ProbePercentile,
+		})
+	case "prometheus":
+		queries, err := mtd.LoadPrometheusQueries(settings.PrometheusQueries)
+		if err != nil {
+			return nil, fmt.Errorf("loading prometheus queries: %w", err)
+		}
+		return mtd.NewPrometheusSource(settings.PrometheusURL, &http.Client{Timeout: 10 * time.Second}, queries, base)
+	default:
+		return nil, fmt.Errorf("unknown metrics source %q, use file, probes or prometheus", settings.Source)
+	}
+}
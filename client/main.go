@@ -152,7 +152,12 @@ func appendLog(logFile string, entry Metrics, mu *sync.Mutex) error {
 		return err
 	}
 
-	return ioutil.WriteFile(logFile, updatedData, 0644)
+	// Replace the file in one step so readers such as the MTD metrics collector never see a partial log
+	tmpFile := logFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, updatedData, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, logFile)
 }
 
 func main() {
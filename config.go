@@ -0,0 +1,524 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"mtd-system/mtd"
+	"mtd-system/ollama"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ControllerConfig is the configuration of the controller, read from one JSON file.
+// Values come from the defaults, then the file, then the environment variables named by the env tags,
+// then the command line flags.
+type ControllerConfig struct {
+	MoveSpace mtd.Config      `json:"move_space"`
+	Strategy  StrategyConfig  `json:"strategy"`
+	Metrics   MetricsConfig   `json:"metrics"`
+	Knowledge KnowledgeConfig `json:"knowledge"`
+	// Elasticsearch is used by the elasticsearch knowledge base and the audit index
+	Elasticsearch ElasticsearchConfig `json:"elasticsearch"`
+	Advisor       AdvisorConfig       `json:"advisor"`
+	Actuator      ActuatorConfig      `json:"actuator"`
+	Schedule      ScheduleConfig      `json:"schedule"`
+	Audit         AuditConfig         `json:"audit"`
+	Server        ServerConfig        `json:"server"`
+}
+
+// StrategyConfig selects the strategy and holds the thresholds and weights it judges metrics against
+type StrategyConfig struct {
+	Name                 mtd.StrategyType `json:"name" env:"MTD_STRATEGY"`
+	mtd.StrategySettings                  // Thresholds and weights
+}
+
+// MetricsConfig selects where the metrics come from
+type MetricsConfig struct {
+	Source            string   `json:"source" env:"MTD_METRICS_SOURCE"` // file, probes or prometheus
+	File              string   `json:"file" env:"MTD_METRICS_FILE"`
+	ProbeLog          string   `json:"probe_log" env:"MTD_PROBE_LOG"`
+	ProbeWindow       Duration `json:"probe_window"`
+	ProbePercentile   float64  `json:"probe_percentile"`
+	PrometheusURL     string   `json:"prometheus_url" env:"PROMETHEUS_URL"`
+	PrometheusQueries string   `json:"prometheus_queries"`
+	// Definitions declares the metrics beyond the built-in ones, found in the extra sections
+	// of the metrics, the thresholds, the Prometheus queries and the policy criteria
+	Definitions []mtd.MetricDefinition `json:"definitions,omitempty"`
+}
+
+// KnowledgeConfig selects the knowledge base of the weighted strategy
+type KnowledgeConfig struct {
+	Backend string `json:"backend" env:"MTD_KNOWLEDGE"` // elasticsearch or file
+	File    string `json:"file" env:"KNOWLEDGE_DATA"`
+	Index   string `json:"index" env:"ELASTICSEARCH_INDEX"`
+}
+
+// ElasticsearchConfig holds how to reach Elasticsearch
+type ElasticsearchConfig struct {
+	URL      string `json:"url" env:"ELASTICSEARCH_URL"`
+	User     string `json:"user" env:"ELASTICSEARCH_USER"`
+	Password string `json:"password" env:"ELASTICSEARCH_PASSWORD"`
+}
+
+// settings returns the connection settings of the mtd package
+func (e ElasticsearchConfig) settings() mtd.ElasticsearchSettings {
+	return mtd.ElasticsearchSettings{URL: e.URL, User: e.User, Password: e.Password}
+}
+
+// AdvisorConfig holds the Ollama settings of the weighted strategy
+type AdvisorConfig struct {
+	URL         string   `json:"url" env:"OLLAMA_URL"`
+	Model       string   `json:"model" env:"OLLAMA_MODEL"`
+	Temperature float64  `json:"temperature"`
+	Timeout     Duration `json:"timeout"`
+	Retries     int      `json:"retries"`
+	RetryDelay  Duration `json:"retry_delay"`
+}
+
+// ActuatorConfig selects how movements are deployed
+type ActuatorConfig struct {
+	Kind        string `json:"kind" env:"MTD_ACTUATOR"` // compose or bluegreen
+	ComposeFile string `json:"compose_file" env:"MTD_COMPOSE_FILE"`
+	// Services is mtd.ComposeSettings.Services
+	Services      map[string]map[string]string `json:"services"`
+	Drain         Duration                     `json:"drain"`
+	HealthTimeout Duration                     `json:"health_timeout"`
+}
+
+// ScheduleConfig sets when the run command moves and learns
+type ScheduleConfig struct {
+	Interval Duration `json:"interval" env:"MTD_INTERVAL"`
+	// WatchInterval is how often the config file is checked for changes, 0 to only reload it on SIGHUP
+	WatchInterval Duration `json:"watch_interval"`
+	LearnWindow   Duration `json:"learn_window"`
+	LearnMinScore float64  `json:"learn_min_score"`
+}
+
+// AuditConfig sets where movements are recorded
+type AuditConfig struct {
+	File  string `json:"file" env:"AUDIT_FILE"`
+	Index string `json:"index" env:"AUDIT_INDEX"`
+}
+
+// ServerConfig sets the addresses the run command listens on
+type ServerConfig struct {
+	ProxyListen   string `json:"proxy_listen" env:"MTD_PROXY_LISTEN"`
+	MetricsListen string `json:"metrics_listen" env:"MTD_METRICS_LISTEN"`
+	APIListen     string `json:"api_listen" env:"MTD_API_LISTEN"`
+	APIToken      string `json:"api_token" env:"MTD_API_TOKEN"`
+}
+
+// ollama returns the settings of the Ollama client
+func (a AdvisorConfig) ollama() ollama.Config {
+	config := ollama.DefaultConfig()
+	config.Endpoint = a.URL
+	config.Model = a.Model
+	config.Temperature = a.Temperature
+	config.Timeout = time.Duration(a.Timeout)
+	config.MaxRetries = a.Retries
+	config.RetryDelay = time.Duration(a.RetryDelay)
+	config.Format = mtd.RecommendationSchema
+	return config
+}
+
+// Duration is a time.Duration written as a string such as "1m30s" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("durations are strings such as \"1m30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// DefaultControllerConfig returns the configuration used for everything the file does not set
+func DefaultControllerConfig() ControllerConfig {
+	advisor := ollama.DefaultConfig()
+	return ControllerConfig{
+		Strategy: StrategyConfig{Name: mtd.Weighted},
+		Metrics: MetricsConfig{
+			Source:            "file",
+			File:              "config/metrics.json",
+			ProbeLog:          "client/client_log.json",
+			ProbeWindow:       Duration(5 * time.Minute),
+			ProbePercentile:   95,
+			PrometheusURL:     "http://localhost:9090",
+			PrometheusQueries: "config/prometheus.json",
+		},
+		Knowledge: KnowledgeConfig{
+			Backend: "elasticsearch",
+			File:    "config/knowledge.json",
+			Index:   "knowledge_base",
+		},
+		Elasticsearch: ElasticsearchConfig{URL: "http://localhost:9200"},
+		Advisor: AdvisorConfig{
+			URL:         advisor.Endpoint,
+			Model:       advisor.Model,
+			Temperature: advisor.Temperature,
+			Timeout:     Duration(advisor.Timeout),
+			Retries:     advisor.MaxRetries,
+			RetryDelay:  Duration(advisor.RetryDelay),
+		},
+		Actuator: ActuatorConfig{
+			Kind:          "compose",
+			ComposeFile:   "./docker/docker-compose.yml",
+			Drain:         Duration(30 * time.Second),
+			HealthTimeout: Duration(2 * time.Minute),
+		},
+		Schedule: ScheduleConfig{
+			Interval:      Duration(time.Minute),
+			WatchInterval: Duration(5 * time.Second),
+			LearnMinScore: 0.1,
+		},
+		Audit: AuditConfig{File: "audit.jsonl"},
+		Server: ServerConfig{
+			ProxyListen:   ":8000",
+			MetricsListen: ":9101",
+		},
+	}
+}
+
+// LoadControllerConfig reads the configuration file over the defaults, then applies the environment overrides
+func LoadControllerConfig(path string) (ControllerConfig, error) {
+	config := DefaultControllerConfig()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	// A misspelt key would otherwise be silently ignored
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	if err := applyEnv(reflect.ValueOf(&config).Elem()); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// applyEnv overrides every field that has an env tag with its environment variable, when it is set
+func applyEnv(v reflect.Value) error {
+	var errs []error
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		info := v.Type().Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		name := info.Tag.Get("env")
+		value, ok := os.LookupEnv(name)
+		if name == "" || !ok || value == "" {
+			continue
+		}
+		if err := setFromString(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setFromString parses value into field according to its type
+func setFromString(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate reports every problem of the configuration at once
+func (c ControllerConfig) Validate() error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	// Move space
+	space := c.MoveSpace
+	list := func(name string, values []string, required bool) {
+		if required && len(values) == 0 {
+			problem("move_space.%s is empty", name)
+		}
+		seen := make(map[string]bool)
+		for _, value := range values {
+			key := strings.ToLower(strings.TrimSpace(value))
+			if key == "" {
+				problem("move_space.%s has an empty value", name)
+				continue
+			}
+			if seen[key] {
+				problem("move_space.%s lists %q twice", name, value)
+			}
+			seen[key] = true
+		}
+	}
+	list("ips", space.IPs, false)
+	list("ports", space.Ports, true)
+	list("oses", space.OSes, true)
+	list("formats", space.Formats, true)
+	list("languages", space.Languages, true)
+	for _, ip := range space.IPs {
+		if net.ParseIP(ip) == nil {
+			problem("move_space.ips: %q is not an IP address", ip)
+		}
+	}
+	for _, port := range space.Ports {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			problem("move_space.ports: %q is not a port number", port)
+		}
+	}
+
+	// Metric definitions
+	defined := make(map[string]bool)
+	for i, def := range c.Metrics.Definitions {
+		if err := def.Validate(); err != nil {
+			problem("metrics.definitions[%d] %q: %v", i, def.Name, err)
+		}
+		switch {
+		case mtd.IsBuiltinMetric(def.Name):
+			problem("metrics.definitions[%d]: %q is a built-in metric", i, def.Name)
+		case defined[def.Name]:
+			problem("metrics.definitions[%d]: %q is defined twice", i, def.Name)
+		}
+		defined[def.Name] = true
+	}
+
+	// Strategy
+	if _, err := mtd.ParseStrategyType(string(c.Strategy.Name)); err != nil {
+		problem("strategy.name: %v", err)
+	}
+	thresholds := c.Strategy.Thresholds
+	if thresholds.ResponseTimeMs <= 0 {
+		problem("strategy.thresholds.response_time_ms must be positive")
+	}
+	if thresholds.ErrorRate <= 0 || thresholds.ErrorRate > 1 {
+		problem("strategy.thresholds.error_rate must be between 0 and 1")
+	}
+	if thresholds.VulnerabilityCount <= 0 {
+		problem("strategy.thresholds.vulnerability_count must be positive")
+	}
+	if thresholds.IntrusionAttempts <= 0 {
+		problem("strategy.thresholds.intrusion_attempts must be positive")
+	}
+	for _, name := range sortedKeys(thresholds.Extra) {
+		if !defined[name] {
+			problem("strategy.thresholds.extra: unknown metric %q, not in metrics.definitions", name)
+		}
+	}
+	weights := c.Strategy.Weights
+	if weights.QualityOfService < 0 || weights.SecurityMetrics < 0 || weights.AssetValue < 0 {
+		problem("strategy.weights cannot be negative")
+	}
+	if sum := weights.QualityOfService + weights.SecurityMetrics + weights.AssetValue; math.Abs(sum-1) > 1e-6 {
+		problem("strategy.weights sum to %.4g instead of 1", sum)
+	}
+
+	// Metrics
+	switch c.Metrics.Source {
+	case "file", "probes", "prometheus":
+	default:
+		problem("metrics.source %q is not file, probes or prometheus", c.Metrics.Source)
+	}
+	if c.Metrics.File == "" {
+		problem("metrics.file is empty")
+	}
+	if c.Metrics.Source == "probes" {
+		if c.Metrics.ProbeLog == "" {
+			problem("metrics.probe_log is empty")
+		}
+		if c.Metrics.ProbeWindow <= 0 {
+			problem("metrics.probe_window must be positive")
+		}
+		if c.Metrics.ProbePercentile <= 0 || c.Metrics.ProbePercentile > 100 {
+			problem("metrics.probe_percentile must be between 0 and 100")
+		}
+	}
+	if c.Metrics.Source == "prometheus" {
+		if !isHTTPURL(c.Metrics.PrometheusURL) {
+			problem("metrics.prometheus_url %q is not an http(s) URL", c.Metrics.PrometheusURL)
+		}
+		if c.Metrics.PrometheusQueries == "" {
+			problem("metrics.prometheus_queries is empty")
+		}
+	}
+
+	// Knowledge base and advisor, only used by the weighted strategy
+	if c.Strategy.Name == mtd.Weighted {
+		switch c.Knowledge.Backend {
+		case "elasticsearch":
+			if c.Knowledge.Index == "" {
+				problem("knowledge.index is empty")
+			}
+		case "file":
+			if c.Knowledge.File == "" {
+				problem("knowledge.file is empty")
+			}
+		default:
+			problem("knowledge.backend %q is not elasticsearch or file", c.Knowledge.Backend)
+		}
+
+		if !isHTTPURL(c.Advisor.URL) {
+			problem("advisor.url %q is not an http(s) URL", c.Advisor.URL)
+		}
+		if c.Advisor.Model == "" {
+			problem("advisor.model is empty")
+		}
+		if c.Advisor.Temperature < 0 || c.Advisor.Temperature > 2 {
+			problem("advisor.temperature must be between 0 and 2")
+		}
+		if c.Advisor.Timeout <= 0 {
+			problem("advisor.timeout must be positive")
+		}
+		if c.Advisor.Retries < 0 {
+			problem("advisor.retries cannot be negative")
+		}
+	}
+
+	// Actuator
+	switch c.Actuator.Kind {
+	case "compose", "bluegreen":
+	default:
+		problem("actuator.kind %q is not compose or bluegreen", c.Actuator.Kind)
+	}
+	if c.Actuator.ComposeFile == "" {
+		problem("actuator.compose_file is empty")
+	}
+	for _, os := range sortedKeys(c.Actuator.Services) {
+		if !contains(space.OSes, os) {
+			problem("actuator.services: unknown os %q, not in move_space.oses", os)
+		}
+		for _, language := range sortedKeys(c.Actuator.Services[os]) {
+			if !contains(space.Languages, language) {
+				problem("actuator.services.%s: unknown language %q, not in move_space.languages", os, language)
+			}
+		}
+	}
+	if len(c.Actuator.Services) > 0 {
+		// With a service table, every reachable variant must have a service to deploy
+		for _, os := range space.OSes {
+			for _, language := range space.Languages {
+				if _, ok := c.Actuator.Services[os][language]; !ok {
+					problem("actuator.services has no service for os %q and language %q", os, language)
+				}
+			}
+		}
+	}
+	if c.Actuator.Kind == "bluegreen" {
+		if c.Actuator.HealthTimeout <= 0 {
+			problem("actuator.health_timeout must be positive")
+		}
+		if c.Actuator.Drain < 0 {
+			problem("actuator.drain cannot be negative")
+		}
+	}
+
+	// Elasticsearch
+	usesElasticsearch := c.Audit.Index != "" || (c.Strategy.Name == mtd.Weighted && c.Knowledge.Backend == "elasticsearch")
+	if usesElasticsearch && !isHTTPURL(c.Elasticsearch.URL) {
+		problem("elasticsearch.url %q is not an http(s) URL", c.Elasticsearch.URL)
+	}
+
+	// Schedule
+	if c.Schedule.Interval <= 0 {
+		problem("schedule.interval must be positive")
+	}
+	if c.Schedule.WatchInterval < 0 {
+		problem("schedule.watch_interval cannot be negative")
+	}
+	if c.Schedule.LearnWindow < 0 {
+		problem("schedule.learn_window cannot be negative")
+	}
+	if c.Schedule.LearnWindow > 0 {
+		if c.Schedule.LearnWindow >= c.Schedule.Interval {
+			problem("schedule.learn_window must be shorter than schedule.interval")
+		}
+		if c.Schedule.LearnMinScore <= 0 || c.Schedule.LearnMinScore > 1 {
+			problem("schedule.learn_min_score must be above 0 and at most 1")
+		}
+	}
+
+	// Servers
+	if c.Server.APIListen != "" && c.Server.APIToken == "" {
+		problem("server.api_listen requires server.api_token (MTD_API_TOKEN)")
+	}
+
+	return errors.Join(problems...)
+}
+
+// sortedKeys returns the keys of m in order, so that problems are reported in a stable order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// registerMetrics makes the metric definitions of the config the ones known to the strategies and knowledge bases,
+// forgetting the definitions registered before. Nothing is registered when one of them is invalid.
+func registerMetrics(definitions []mtd.MetricDefinition) error {
+	return mtd.ReplaceMetrics(definitions)
+}
+
+// isHTTPURL reports whether value is an absolute http or https URL
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// contains reports whether values holds value, ignoring case
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
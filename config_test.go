@@ -0,0 +1,274 @@
+package main
+
+import (
+	"flag"
+	"mtd-system/mtd"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a config Validate accepts
+func validConfig() ControllerConfig {
+	config := DefaultControllerConfig()
+	config.MoveSpace = testMoveSpace()
+	config.Strategy.Thresholds = mtd.Thresholds{ResponseTimeMs: 300, ErrorRate: 0.05, VulnerabilityCount: 10, IntrusionAttempts: 5}
+	config.Strategy.Weights = mtd.Weights{QualityOfService: 0.4, SecurityMetrics: 0.4, AssetValue: 0.2}
+	return config
+}
+
+// writeConfig writes a config file in a temporary directory and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "controller.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *ControllerConfig)
+		want   []string // Every problem reported, in order
+	}{
+		{
+			name:   "valid",
+			change: func(c *ControllerConfig) {},
+		},
+		{
+			name:   "empty move space",
+			change: func(c *ControllerConfig) { c.MoveSpace = mtd.Config{} },
+			want: []string{
+				"move_space.ports is empty",
+				"move_space.oses is empty",
+				"move_space.formats is empty",
+				"move_space.languages is empty",
+			},
+		},
+		{
+			name: "invalid move space values",
+			change: func(c *ControllerConfig) {
+				c.MoveSpace.IPs = []string{"10.0.0.1", "nope"}
+				c.MoveSpace.Ports = []string{"8080", "8080", "0"}
+				c.MoveSpace.OSes = []string{"ubuntu", " "}
+				c.MoveSpace.Languages = []string{"go", "Go"}
+			},
+			want: []string{
+				`move_space.ports lists "8080" twice`,
+				"move_space.oses has an empty value",
+				`move_space.languages lists "Go" twice`,
+				`move_space.ips: "nope" is not an IP address`,
+				`move_space.ports: "0" is not a port number`,
+			},
+		},
+		{
+			name: "strategy",
+			change: func(c *ControllerConfig) {
+				c.Strategy.Name = "bogus"
+				c.Strategy.Thresholds = mtd.Thresholds{ErrorRate: 2, Extra: map[string]float64{"auth_failures": 3}}
+				c.Strategy.Weights = mtd.Weights{QualityOfService: -0.5, SecurityMetrics: 1}
+			},
+			want: []string{
+				`strategy.name: unknown strategy "bogus", available strategies: [random round_robin weighted]`,
+				"strategy.thresholds.response_time_ms must be positive",
+				"strategy.thresholds.error_rate must be between 0 and 1",
+				"strategy.thresholds.vulnerability_count must be positive",
+				"strategy.thresholds.intrusion_attempts must be positive",
+				`strategy.thresholds.extra: unknown metric "auth_failures", not in metrics.definitions`,
+				"strategy.weights cannot be negative",
+				"strategy.weights sum to 0.5 instead of 1",
+			},
+		},
+		{
+			name: "metric definitions",
+			change: func(c *ControllerConfig) {
+				auth := mtd.MetricDefinition{Name: "auth_failures", Category: mtd.CategorySecurity, Direction: mtd.LowerIsBetter, Scale: 5}
+				c.Metrics.Definitions = []mtd.MetricDefinition{
+					auth,
+					{Name: "error_rate", Category: mtd.CategoryQualityOfService, Direction: mtd.LowerIsBetter, Scale: 1},
+					auth,
+					{Name: "uptime", Category: "availability", Direction: mtd.HigherIsBetter},
+				}
+				c.Strategy.Thresholds.Extra = map[string]float64{"auth_failures": 3}
+			},
+			want: []string{
+				`metrics.definitions[1]: "error_rate" is a built-in metric`,
+				`metrics.definitions[2]: "auth_failures" is defined twice`,
+				`metrics.definitions[3] "uptime": category "availability" is not quality_of_service, security_metrics or asset_value, scale is not positive`,
+			},
+		},
+		{
+			name: "probes source",
+			change: func(c *ControllerConfig) {
+				c.Metrics.Source = "probes"
+				c.Metrics.ProbeLog = ""
+				c.Metrics.ProbeWindow = 0
+				c.Metrics.ProbePercentile = 101
+			},
+			want: []string{
+				"metrics.probe_log is empty",
+				"metrics.probe_window must be positive",
+				"metrics.probe_percentile must be between 0 and 100",
+			},
+		},
+		{
+			name: "weighted dependencies",
+			change: func(c *ControllerConfig) {
+				c.Knowledge.Backend = "redis"
+				c.Advisor.URL = "localhost:11434"
+				c.Advisor.Model = ""
+				c.Advisor.Timeout = 0
+			},
+			want: []string{
+				`knowledge.backend "redis" is not elasticsearch or file`,
+				`advisor.url "localhost:11434" is not an http(s) URL`,
+				"advisor.model is empty",
+				"advisor.timeout must be positive",
+			},
+		},
+		{
+			name: "weighted dependencies are ignored by other strategies",
+			change: func(c *ControllerConfig) {
+				c.Strategy.Name = mtd.RoundRobin
+				c.Knowledge.Backend = "redis"
+				c.Advisor.URL = ""
+				c.Elasticsearch.URL = ""
+			},
+		},
+		{
+			name: "actuator services",
+			change: func(c *ControllerConfig) {
+				c.Actuator.Kind = "bluegreen"
+				c.Actuator.HealthTimeout = 0
+				c.Actuator.Services = map[string]map[string]string{
+					"ubuntu":  {"go": "app_ubuntu_go", "python": "app_ubuntu_python", "rust": "app_ubuntu_rust"},
+					"alpine":  {"go": "app_alpine_go"},
+					"windows": {"go": "app_windows_go"},
+				}
+			},
+			want: []string{
+				`actuator.services.ubuntu: unknown language "rust", not in move_space.languages`,
+				`actuator.services: unknown os "windows", not in move_space.oses`,
+				`actuator.services has no service for os "alpine" and language "python"`,
+				"actuator.health_timeout must be positive",
+			},
+		},
+		{
+			name: "schedule and servers",
+			change: func(c *ControllerConfig) {
+				c.Actuator.Kind = "kubernetes"
+				c.Schedule.Interval = Duration(time.Minute)
+				c.Schedule.LearnWindow = Duration(2 * time.Minute)
+				c.Schedule.LearnMinScore = 0
+				c.Server.APIListen = ":9102"
+			},
+			want: []string{
+				`actuator.kind "kubernetes" is not compose or bluegreen`,
+				"schedule.learn_window must be shorter than schedule.interval",
+				"schedule.learn_min_score must be above 0 and at most 1",
+				"server.api_listen requires server.api_token (MTD_API_TOKEN)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validConfig()
+			tt.change(&config)
+			err := config.Validate()
+
+			var got []string
+			if err != nil {
+				got = strings.Split(err.Error(), "\n")
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Validate reported:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestLoadControllerConfig(t *testing.T) {
+	config, err := LoadControllerConfig("config/controller.json")
+	if err != nil {
+		t.Fatalf("LoadControllerConfig: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("config/controller.json is invalid:\n%v", err)
+	}
+
+	if _, err := LoadControllerConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loading a missing file succeeded")
+	}
+}
+
+func TestLoadControllerConfigUnknownFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "misspelt section", content: `{"schedual": {"interval": "1m"}}`, want: `unknown field "schedual"`},
+		{name: "misspelt key", content: `{"strategy": {"nmae": "random"}}`, want: `unknown field "nmae"`},
+		{name: "misspelt threshold", content: `{"strategy": {"thresholds": {"error": 0.1}}}`, want: `unknown field "error"`},
+		{name: "invalid duration", content: `{"schedule": {"interval": 60}}`, want: "durations are strings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadControllerConfig(writeConfig(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigOverrideOrder(t *testing.T) {
+	path := writeConfig(t, `{
+		"metrics": {"file": "file-metrics.json", "probe_log": "file-probes.json"},
+		"knowledge": {"index": "file-index"},
+		"schedule": {"interval": "2m"}
+	}`)
+	t.Setenv("MTD_METRICS_FILE", "env-metrics.json")
+	t.Setenv("MTD_PROBE_LOG", "env-probes.json")
+	t.Setenv("MTD_INTERVAL", "3m")
+	t.Setenv("ELASTICSEARCH_INDEX", "") // Empty variables are ignored
+	t.Setenv("MTD_ACTUATOR", "bluegreen")
+
+	var o options
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o.configFlags(fs)
+	o.actuatorFlags(fs)
+	o.daemonFlags(fs)
+	if err := o.parse(fs, []string{"-config", path, "-probe-log", "flag-probes.json"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	config := o.config
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "default", got: config.Knowledge.File, want: "config/knowledge.json"},
+		{name: "file over default", got: config.Knowledge.Index, want: "file-index"},
+		{name: "env over file", got: config.Metrics.File, want: "env-metrics.json"},
+		{name: "env duration over file", got: config.Schedule.Interval, want: Duration(3 * time.Minute)},
+		{name: "env over default", got: config.Actuator.Kind, want: "bluegreen"},
+		{name: "flag over env", got: config.Metrics.ProbeLog, want: "flag-probes.json"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	t.Setenv("MTD_INTERVAL", "soon")
+	if _, err := LoadControllerConfig(path); err == nil || !strings.Contains(err.Error(), "MTD_INTERVAL") {
+		t.Errorf("invalid MTD_INTERVAL error = %v", err)
+	}
+}
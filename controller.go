@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mtd-system/mtd"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historySize is the number of movements the controller remembers for the control API
+const historySize = 100
+
+// controller runs the MTD loop: it collects the metrics, asks the strategy for a decision and applies it
+type controller struct {
+	actuator mtd.Actuator
+	metrics  mtd.MetricsSource
+	// deps are what the strategy is rebuilt from when the config is reloaded
+	deps mtd.StrategyDependencies
+	// dryRun prints every decision to out instead of applying it
+	dryRun bool
+	out    io.Writer
+	// audit records every movement, nil to disable the audit trail
+	audit mtd.AuditLog
+	// telemetry counts decisions and fallbacks, nil to disable it
+	telemetry *controllerMetrics
+	// learner turns movements that improved the metrics into policies, nil to disable learning
+	learner *mtd.Learner
+
+	// moving serialises movements, whether scheduled or requested through the control API
+	moving   sync.Mutex
+	learning sync.WaitGroup
+
+	// mu guards the state below, which the control API reads
+	mu sync.Mutex
+	// strategy, config and policy are replaced together when the config is reloaded
+	strategy mtd.Strategy
+	config   mtd.Config // Configurations the system can move between
+	policy   StrategyConfig
+	live     *mtd.MovementDecision // Last applied decision
+	history  []mtd.AuditRecord     // Last movements, oldest first
+	paused   bool
+	interval time.Duration
+	next     time.Time // Next scheduled movement, zero when none is scheduled
+}
+
+// controllerStatus is the state of the controller reported by the control API
+type controllerStatus struct {
+	Paused       bool                  `json:"paused"`
+	DryRun       bool                  `json:"dry_run"`
+	Interval     string                `json:"interval,omitempty"`
+	NextMovement *time.Time            `json:"next_movement,omitempty"`
+	Live         *mtd.MovementDecision `json:"live,omitempty"`
+	Variant      string                `json:"variant,omitempty"`
+	LastMovement *mtd.AuditRecord      `json:"last_movement,omitempty"`
+}
+
+// errInvalidTarget is returned when a forced target names a configuration that does not exist
+var errInvalidTarget = errors.New("invalid target")
+
+// move collects the current metrics, asks the strategy for a decision and applies it,
+// recording the outcome in the audit trail
+func (c *controller) move(ctx context.Context) error {
+	_, err := c.moveTo(ctx, nil)
+	return err
+}
+
+// moveTo applies a movement to target, or to the strategy's decision when target is nil.
+// Empty fields of target keep their live value.
+func (c *controller) moveTo(ctx context.Context, target *mtd.MovementDecision) (mtd.AuditRecord, error) {
+	c.moving.Lock()
+	defer c.moving.Unlock()
+
+	start := time.Now()
+	metrics, err := c.metrics.Collect(ctx)
+	if err != nil {
+		if target == nil {
+			return mtd.AuditRecord{}, fmt.Errorf("collecting metrics: %w", err)
+		}
+		// A forced movement does not depend on the metrics, they are only recorded
+		log.Printf("Error collecting metrics for the forced movement: %v", err)
+	}
+
+	decision, actuation, err := c.decideAndApply(ctx, metrics, target)
+	record := mtd.NewAuditRecord(start, metrics, decision, actuation, err)
+	if c.audit != nil {
+		if auditErr := c.audit.Record(ctx, record); auditErr != nil {
+			log.Printf("Error writing audit record: %v", auditErr)
+		}
+	}
+
+	c.mu.Lock()
+	previous := c.live
+	if err == nil && actuation == mtd.ActuationApplied {
+		c.live = decision
+	}
+	c.history = append(c.history, record)
+	if len(c.history) > historySize {
+		c.history = c.history[len(c.history)-historySize:]
+	}
+	c.mu.Unlock()
+
+	if err == nil && actuation == mtd.ActuationApplied && c.learner != nil {
+		rotatedIP := previous != nil && previous.IP != decision.IP
+		c.learning.Add(1)
+		go func() {
+			defer c.learning.Done()
+			if err := c.learner.Observe(ctx, metrics, *decision, rotatedIP); err != nil && ctx.Err() == nil {
+				log.Printf("Error learning from movement: %v", err)
+			}
+		}()
+	}
+	return record, err
+}
+
+// decideAndApply returns the decision, nil if none was reached, and the actuation result, empty if it was not deployed
+func (c *controller) decideAndApply(ctx context.Context, metrics mtd.Metrics, target *mtd.MovementDecision) (*mtd.MovementDecision, string, error) {
+	c.mu.Lock()
+	strategy, config := c.strategy, c.config
+	c.mu.Unlock()
+
+	var decision mtd.MovementDecision
+	var err error
+	if target != nil {
+		decision, err = c.forced(*target, config)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		log.Printf("Available configurations:\n\t\t%+v", config)
+		decision, err = strategy.Decide(ctx, metrics, config)
+		if err != nil {
+			return nil, "", fmt.Errorf("deciding movement: %w", err)
+		}
+	}
+	if c.telemetry != nil {
+		c.telemetry.observeDecision(decision)
+	}
+
+	if c.dryRun {
+		encoder := json.NewEncoder(c.out)
+		encoder.SetIndent("", "    ")
+		if err := encoder.Encode(decision); err != nil {
+			return &decision, mtd.ActuationSkipped, fmt.Errorf("printing decision: %w", err)
+		}
+		log.Printf("Dry run, MTD changes not applied")
+		return &decision, mtd.ActuationSkipped, nil
+	}
+
+	// The actuator may deploy the decision differently, such as on a side port, what went live is recorded
+	applied, err := c.actuator.Apply(ctx, decision)
+	if err != nil {
+		return &decision, mtd.ActuationFailed, fmt.Errorf("switching environment: %w", err)
+	}
+	decision = applied
+
+	// Strategies that rotate away from the live movement only learn about the ones that were deployed
+	if observer, ok := strategy.(mtd.MovementObserver); ok {
+		observer.Applied(decision)
+	}
+
+	log.Printf("MTD changes applied: IP=%s PORT=%s OS=%s, Format=%s, Language=%s", decision.IP, decision.Port, decision.OS, decision.Format, decision.Language)
+	return &decision, mtd.ActuationApplied, nil
+}
+
+// forced checks a target requested by an operator against the config, filling its empty fields from the live decision
+func (c *controller) forced(target mtd.MovementDecision, config mtd.Config) (mtd.MovementDecision, error) {
+	var live mtd.MovementDecision
+	c.mu.Lock()
+	if c.live != nil {
+		live = *c.live
+	}
+	c.mu.Unlock()
+
+	if target.IP == "" {
+		target.IP = live.IP
+	}
+	decision, adjustments := mtd.ConstrainDecision(target, live, config)
+	for _, adjustment := range adjustments {
+		// Only values that were given and do not exist are refused, empty ones keep the live value
+		if adjustment.Proposed != "" && !strings.EqualFold(strings.TrimSpace(adjustment.Proposed), adjustment.Applied) {
+			return mtd.MovementDecision{}, fmt.Errorf("%w: %q is not a configured %s", errInvalidTarget, adjustment.Proposed, adjustment.Field)
+		}
+	}
+
+	decision.Strategy = mtd.Manual
+	decision.Score = 0
+	decision.Timestamp = time.Now()
+	decision.Adjustments = adjustments
+	decision.Reasoning = nil
+	log.Printf("Forced movement to %s", mtd.VariantName(decision))
+	return decision, nil
+}
+
+// run applies a movement right away and then once every interval until ctx is cancelled.
+// Scheduled movements are skipped while the controller is paused.
+func (c *controller) run(ctx context.Context, interval time.Duration) {
+	log.Printf("MTD daemon started, moving every %s", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	c.mu.Lock()
+	c.interval = interval
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		paused := c.paused
+		c.next = time.Now().Add(interval)
+		c.mu.Unlock()
+
+		if paused {
+			log.Printf("MTD daemon paused, movement skipped")
+		} else if err := c.move(ctx); err != nil {
+			log.Printf("Error applying movement: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			c.learning.Wait()
+			log.Printf("MTD daemon stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// setPaused pauses or resumes the scheduled movements
+func (c *controller) setPaused(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = paused
+}
+
+// status reports the state of the controller
+func (c *controller) status() controllerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := controllerStatus{
+		Paused: c.paused,
+		DryRun: c.dryRun,
+		Live:   c.live,
+	}
+	if c.interval > 0 {
+		status.Interval = c.interval.String()
+	}
+	if !c.paused && !c.next.IsZero() {
+		next := c.next
+		status.NextMovement = &next
+	}
+	if c.live != nil {
+		status.Variant = mtd.VariantName(*c.live)
+	}
+	if len(c.history) > 0 {
+		last := c.history[len(c.history)-1]
+		status.LastMovement = &last
+	}
+	return status
+}
+
+// decisions returns up to limit of the last movements, newest first
+func (c *controller) decisions(limit int) []mtd.AuditRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if limit <= 0 || limit > len(c.history) {
+		limit = len(c.history)
+	}
+	records := make([]mtd.AuditRecord, 0, limit)
+	for i := len(c.history) - 1; i >= len(c.history)-limit; i-- {
+		records = append(records, c.history[i])
+	}
+	return records
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"mtd-system/mtd"
+	"mtd-system/telemetry"
+	"time"
+)
+
+// controllerMetrics are the metrics the controller exposes about itself on /metrics
+type controllerMetrics struct {
+	registry        *telemetry.Registry
+	decisions       *telemetry.Counter
+	fallbacks       *telemetry.Counter
+	knowledgeTime   *telemetry.Histogram
+	advisorTime     *telemetry.Histogram
+	parseFailures   *telemetry.Counter
+	actuations      *telemetry.Counter
+	actuationTime   *telemetry.Histogram
+	activeVariant   *telemetry.Gauge
+	lastMovementSec *telemetry.Gauge
+	configReloads   *telemetry.Counter
+}
+
+func newControllerMetrics() *controllerMetrics {
+	r := telemetry.NewRegistry()
+	return &controllerMetrics{
+		registry:        r,
+		decisions:       r.Counter("mtd_decisions_total", "Movement decisions made, by strategy.", "strategy"),
+		fallbacks:       r.Counter("mtd_fallbacks_total", "Decisions that could not follow the advisor, by reason.", "reason"),
+		knowledgeTime:   r.Histogram("mtd_knowledge_search_duration_seconds", "Duration of knowledge base searches, by result.", nil, "result"),
+		advisorTime:     r.Histogram("mtd_advisor_duration_seconds", "Duration of advisor (LLM) requests, by result.", nil, "result"),
+		parseFailures:   r.Counter("mtd_advisor_parse_failures_total", "Advisor answers that held no valid recommendation."),
+		actuations:      r.Counter("mtd_actuations_total", "Movements deployed, by result.", "result"),
+		actuationTime:   r.Histogram("mtd_actuation_duration_seconds", "Duration of movement deployments, by result.", nil, "result"),
+		activeVariant:   r.Gauge("mtd_active_variant", "Variant deployed by the last successful movement, always 1.", "os", "language", "format", "port", "ip"),
+		lastMovementSec: r.Gauge("mtd_last_movement_timestamp_seconds", "Unix time of the last successful movement."),
+		configReloads:   r.Counter("mtd_config_reloads_total", "Config reloads, by result.", "result"),
+	}
+}
+
+// result labels an outcome
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// observeDecision records what a decision tells about the strategy, its fallbacks and its advisor answers
+func (m *controllerMetrics) observeDecision(decision mtd.MovementDecision) {
+	m.decisions.Inc(string(decision.Strategy))
+	reasoning := decision.Reasoning
+	if reasoning == nil {
+		return
+	}
+	if reasoning.Fallback != "" {
+		m.fallbacks.Inc(string(reasoning.Fallback))
+	}
+	// Every answer but the one the recommendation was parsed from failed to parse
+	failures := len(reasoning.AdvisorAnswers)
+	if reasoning.Recommendation != nil {
+		failures--
+	}
+	if failures > 0 {
+		m.parseFailures.Add(float64(failures))
+	}
+}
+
+// observeActive records the variant a successful movement deployed
+func (m *controllerMetrics) observeActive(decision mtd.MovementDecision) {
+	m.activeVariant.Reset()
+	m.activeVariant.Set(1, decision.OS, decision.Language, decision.Format, decision.Port, decision.IP)
+	m.lastMovementSec.Set(float64(time.Now().Unix()))
+}
+
+// instrumentedKnowledge times the searches of a knowledge base
+type instrumentedKnowledge struct {
+	mtd.KnowledgeBase
+	metrics *controllerMetrics
+}
+
+func (k instrumentedKnowledge) Search(ctx context.Context, metrics mtd.Metrics, weights mtd.Weights) ([]mtd.ScoredPolicy, error) {
+	start := time.Now()
+	policies, err := k.KnowledgeBase.Search(ctx, metrics, weights)
+	k.metrics.knowledgeTime.Observe(time.Since(start).Seconds(), result(err))
+	return policies, err
+}
+
+// instrumentAdvisor times the requests of an advisor
+func instrumentAdvisor(advisor mtd.Advisor, metrics *controllerMetrics) mtd.Advisor {
+	return mtd.AdvisorFunc(func(ctx context.Context, prompt string) (string, error) {
+		start := time.Now()
+		answer, err := advisor.Advise(ctx, prompt)
+		metrics.advisorTime.Observe(time.Since(start).Seconds(), result(err))
+		return answer, err
+	})
+}
+
+// instrumentedActuator counts and times the deployments of an actuator
+type instrumentedActuator struct {
+	mtd.Actuator
+	metrics *controllerMetrics
+}
+
+func (a instrumentedActuator) Apply(ctx context.Context, decision mtd.MovementDecision) (mtd.MovementDecision, error) {
+	start := time.Now()
+	applied, err := a.Actuator.Apply(ctx, decision)
+	a.metrics.actuationTime.Observe(time.Since(start).Seconds(), result(err))
+	a.metrics.actuations.Inc(result(err))
+	if err == nil {
+		a.metrics.observeActive(applied)
+	}
+	return applied, err
+}
+
+// UseMoveSpace passes a reloaded move space on to the actuator, if it depends on it
+func (a instrumentedActuator) UseMoveSpace(space mtd.Config) {
+	if aware, ok := a.Actuator.(mtd.MoveSpaceAware); ok {
+		aware.UseMoveSpace(space)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"mtd-system/mtd"
+)
+
+// runKB runs the `kb` subcommand, which manages the Elasticsearch knowledge base
+func runKB(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "ingest" {
+		return errors.New("usage: kb ingest [-file knowledge.json] [-index name] [-es-url url] [-es-user user] [-es-password password]")
+	}
+
+	var o options
+	fs := flag.NewFlagSet("kb ingest", flag.ExitOnError)
+	o.configFlags(fs)
+	o.elasticFlags(fs)
+	fs.StringVar(&o.config.Knowledge.File, "file", o.config.Knowledge.File, "knowledge.json to ingest, same as -knowledge-file (KNOWLEDGE_DATA)")
+	fs.StringVar(&o.config.Knowledge.Index, "index", o.config.Knowledge.Index, "Elasticsearch index of the knowledge base, same as -es-index (ELASTICSEARCH_INDEX)")
+	if err := o.parse(fs, args[1:]); err != nil {
+		return err
+	}
+	file, index := o.config.Knowledge.File, o.config.Knowledge.Index
+	if err := registerMetrics(o.config.Metrics.Definitions); err != nil {
+		return err
+	}
+
+	policies, err := mtd.LoadPolicies(file)
+	if err != nil {
+		return fmt.Errorf("loading knowledge data: %w", err)
+	}
+
+	es, err := mtd.ConnectElasticsearch(o.config.Elasticsearch.settings())
+	if err != nil {
+		return fmt.Errorf("initializing Elasticsearch: %w", err)
+	}
+
+	if err := mtd.CreateKnowledgeIndex(ctx, es, index); err != nil {
+		return err
+	}
+
+	report, err := mtd.IngestKnowledge(ctx, es, index, policies)
+	if err != nil {
+		return err
+	}
+
+	for _, failure := range report.Failed {
+		log.Printf("Policy #%d %q (id %s) not ingested: %s", failure.Position, failure.PolicyName, failure.ID, failure.Reason)
+	}
+	log.Printf("Knowledge data ingested into %s: %d indexed, %d failed", index, report.Indexed, len(report.Failed))
+
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d policies could not be ingested", len(report.Failed))
+	}
+	return nil
+}
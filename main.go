@@ -1,130 +1,241 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
-	"math/rand"
 	"mtd-system/mtd"
+	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
-type Config struct {
-	Ports     []string `json:"ports"`
-	OSes      []string `json:"oses"`
-	Formats   []string `json:"formats"`
-	Languages []string `json:"languages"`
-}
-
-func loadAppConfig(filepath string) (Config, error) {
-	var config Config
-	data, err := ioutil.ReadFile(filepath)
-	if err != nil {
-		return config, err
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
-	err = json.Unmarshal(data, &config)
-	return config, err
+	return fallback
 }
 
-func loadMetricsConfig(filepath string) (mtd.Metrics, error) {
-	return mtd.LoadMetrics(filepath)
+// command is a subcommand of the controller
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
 }
 
-func selectRandom(options []string) string {
-	rand.Seed(time.Now().UnixNano())
-	return options[rand.Intn(len(options))]
+var commands = []command{
+	{"run", "keep moving the system every interval, serving the proxy, metrics and control API", runController},
+	{"decide", "decide one movement and print it with its reasoning, without applying it", runDecide},
+	{"apply", "decide one movement, or force one, and apply it", runApply},
+	{"status", "show the status of a running controller through its control API", runStatus},
+	{"kb", "manage the Elasticsearch knowledge base", runKB},
+	{"validate-config", "check the configuration files and report every problem", runValidateConfig},
 }
 
-func executeScript(scriptPath string, arg []string) error {
-	cmd := exec.Command(scriptPath, arg...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
 }
 
-func executeScriptNoArg(scriptPath string) error {
-	cmd := exec.Command("bash", scriptPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "help" {
+		usage()
+		os.Exit(2)
+	}
+
+	// Stop on SIGINT/SIGTERM, cancelling any movement in progress
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(ctx, os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+	usage()
+	os.Exit(2)
 }
 
-func main() {
-	config, err := loadAppConfig("config/config.json")
+// runController runs the `run` subcommand, the long running controller
+func runController(ctx context.Context, args []string) error {
+	var o options
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	o.decisionFlags(fs)
+	o.auditFlags(fs)
+	o.actuatorFlags(fs)
+	o.daemonFlags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	c, cleanup, err := o.newController(ctx, controllerMode{daemon: true, apply: true})
+	defer cleanup()
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		return err
 	}
 
-	metrics, err := loadMetricsConfig("config/metrics.json")
+	interval := time.Duration(o.config.Schedule.Interval)
+	watch := time.Duration(o.config.Schedule.WatchInterval)
+	go watchConfig(ctx, c, o.configFile, watch, o.config, func() (ControllerConfig, error) {
+		return o.reload(fs, args)
+	})
+	c.run(ctx, interval)
+	return nil
+}
+
+// runDecide runs the `decide` subcommand, which prints a decision without applying it
+func runDecide(ctx context.Context, args []string) error {
+	var o options
+	fs := flag.NewFlagSet("decide", flag.ExitOnError)
+	o.decisionFlags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	c, cleanup, err := o.newController(ctx, controllerMode{})
+	defer cleanup()
 	if err != nil {
-		log.Fatalf("Error loading metrics config: %v", err)
+		return err
+	}
+	return c.move(ctx)
+}
+
+// runApply runs the `apply` subcommand, which applies a single movement
+func runApply(ctx context.Context, args []string) error {
+	var o options
+	var target mtd.MovementDecision
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	o.decisionFlags(fs)
+	o.auditFlags(fs)
+	o.actuatorFlags(fs)
+	fs.StringVar(&target.IP, "ip", "", "force the IP instead of asking the strategy")
+	fs.StringVar(&target.Port, "port", "", "force the port instead of asking the strategy")
+	fs.StringVar(&target.OS, "os", "", "force the OS instead of asking the strategy")
+	fs.StringVar(&target.Format, "format", "", "force the format instead of asking the strategy")
+	fs.StringVar(&target.Language, "language", "", "force the language instead of asking the strategy")
+	if err := o.parse(fs, args); err != nil {
+		return err
 	}
 
-	// Initialize Elasticsearch
-	es, err := mtd.InitializeElasticsearch()
+	c, cleanup, err := o.newController(ctx, controllerMode{apply: true})
+	defer cleanup()
 	if err != nil {
-		log.Fatalf("Error initializing Elasticsearch: %v", err)
-	}
-
-	// Initialize strategies
-	// roundRobin := mtd.NewRoundRobinStrategy()
-	// randomStrategy := mtd.NewRandomStrategy()
-	weightedStrategy := mtd.NewWeightedStrategy(mtd.MetricsWeights{
-		QualityOfService: metrics.StrategySettings.Weights.QualityOfService,
-		SecurityMetrics:  metrics.StrategySettings.Weights.SecurityMetrics,
-		AssetValue:       metrics.StrategySettings.Weights.AssetValue,
-	}, mtd.StrategySettings{
-		Thresholds: metrics.StrategySettings.Thresholds,
-	})
+		return err
+	}
+
+	if target.IP == "" && target.Port == "" && target.OS == "" && target.Format == "" && target.Language == "" {
+		return c.move(ctx)
+	}
+	_, err = c.moveTo(ctx, &target)
+	return err
+}
 
-	// Select strategy (WeightedStrategy in this example)
-	strategy := weightedStrategy
-
-	// interval := 1.0 * time.Minute
-	// fmt.Printf("\nChangeInterval: %d min", interval)
-	// ticker := time.NewTicker(interval) // Set desired interval
-	// defer ticker.Stop()
-
-	// For demonstration, reduce ticker interval
-	// ticker = time.NewTicker(interval)
-
-	// var mu sync.Mutex
-	// currentPortIndex := 0
-	// currentOSIndex := 0
-	// currentFormatIndex := 04
-	// currentLanguageIndex := 0
-
-	// for {
-	// 	select {
-	// 	case <-ticker.C:
-	// 		mu.Lock()
-	// Round-Robin Selection
-
-	// fmt.Println(len(config.Ports), len(config.OSes), len(config.Formats), len(config.Languages))
-	log.Printf("Available configurations:\n\t\t%+v", config)
-	decision, err := strategy.Decide(metrics, mtd.Config{
-		Ports:     config.Ports,
-		OSes:      config.OSes,
-		Formats:   config.Formats,
-		Languages: config.Languages,
-	}, es)
+// runStatus runs the `status` subcommand, which queries the control API of a running controller
+func runStatus(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	apiURL := fs.String("api-url", envOrDefault("MTD_API_URL", "http://localhost:9102"), "control API of the running controller (MTD_API_URL)")
+	apiToken := fs.String("api-token", os.Getenv("MTD_API_TOKEN"), "bearer token of the control API (MTD_API_TOKEN)")
+	fs.Parse(args)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(*apiURL, "/")+"/status", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*apiToken)
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		log.Printf("Error deciding movement: %v", err)
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("control API answered %s: %s", res.Status, strings.TrimSpace(string(body)))
 	}
 
-	// log.Printf("Applying MTD changes: %+v", decision)
+	var status bytes.Buffer
+	if err := json.Indent(&status, body, "", "    "); err != nil {
+		return err
+	}
+	fmt.Println(status.String())
+	return nil
+}
 
-	// mu.Unlock()
+// serve serves handler on addr until ctx is cancelled
+func serve(ctx context.Context, name, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("%s listening on %s", name, addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
 
-	args := []string{"./scripts/set_env.sh", decision.Port, decision.Format, decision.Language, decision.OS}
-	err = executeScript("bash", args)
+// newActuator creates the actuator deploying decisions to the variants of space, along with the proxy
+// in front of it when proxyListen is set, which only makes sense for a long running controller
+func newActuator(ctx context.Context, settings ActuatorConfig, space mtd.Config, proxyListen string) (mtd.Actuator, error) {
+	compose, err := mtd.NewComposeActuator(mtd.ComposeSettings{File: settings.ComposeFile, Services: settings.Services})
 	if err != nil {
-		log.Printf("Error switching OS: %v", err)
+		return nil, fmt.Errorf("creating actuator: %w", err)
+	}
+
+	var proxy *mtd.Proxy
+	if proxyListen != "" {
+		proxy = mtd.NewProxy()
+		go func() {
+			if err := proxy.ListenAndServe(ctx, proxyListen); err != nil {
+				log.Fatalf("Error serving proxy: %v", err)
+			}
+		}()
 	}
 
-	log.Printf("MTD changes applied: PORT=%s OS=%s, Format=%s, Language=%s", decision.Port, decision.OS, decision.Format, decision.Language)
-	// 	}
-	// }
+	var actuator mtd.Actuator
+	switch settings.Kind {
+	case "compose":
+		actuator = compose
+		if proxy != nil {
+			actuator = mtd.NewRoutedActuator(compose, proxy)
+		}
+	case "bluegreen":
+		if proxy == nil {
+			return nil, errors.New("the bluegreen actuator requires the run command and -proxy-listen")
+		}
+		actuator, err = mtd.NewBlueGreenActuator(compose, proxy, mtd.BlueGreenSettings{
+			Ports:         space.Ports,
+			HealthTimeout: time.Duration(settings.HealthTimeout),
+			DrainTimeout:  time.Duration(settings.Drain),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating actuator: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown actuator %q, use compose or bluegreen", settings.Kind)
+	}
+	return actuator, nil
 }
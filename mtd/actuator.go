@@ -0,0 +1,85 @@
+package mtd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Actuator applies a movement decision to the running environment.
+// It returns the decision that went live, which may differ from the requested one, such as its port.
+type Actuator interface {
+	Apply(ctx context.Context, decision MovementDecision) (MovementDecision, error)
+}
+
+// MoveSpaceAware is implemented by actuators that depend on the move space, which may be reloaded while they run
+type MoveSpaceAware interface {
+	UseMoveSpace(space Config)
+}
+
+var (
+	_ Actuator = (*ComposeActuator)(nil)
+	_ Actuator = (*BlueGreenActuator)(nil)
+	_ Actuator = (*RoutedActuator)(nil)
+
+	_ io.Closer      = (*BlueGreenActuator)(nil)
+	_ MoveSpaceAware = (*BlueGreenActuator)(nil)
+)
+
+// ActuationError describes a failed step of a movement along with the output of the command that failed
+type ActuationError struct {
+	Service string
+	Stage   string
+	Stdout  string
+	Stderr  string
+	Err     error
+}
+
+func (e *ActuationError) Error() string {
+	msg := fmt.Sprintf("%s %s: %v", e.Stage, e.Service, e.Err)
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+func (e *ActuationError) Unwrap() error {
+	return e.Err
+}
+
+// RoutedActuator deploys decisions with another actuator, then routes the traffic to the deployed variant
+type RoutedActuator struct {
+	actuator Actuator
+	router   TrafficSwitch
+}
+
+// NewRoutedActuator creates a new RoutedActuator
+func NewRoutedActuator(actuator Actuator, router TrafficSwitch) *RoutedActuator {
+	return &RoutedActuator{
+		actuator: actuator,
+		router:   router,
+	}
+}
+
+// Apply deploys the decision and switches the traffic to it
+func (a *RoutedActuator) Apply(ctx context.Context, decision MovementDecision) (MovementDecision, error) {
+	decision, err := a.actuator.Apply(ctx, decision)
+	if err != nil {
+		return MovementDecision{}, err
+	}
+	if _, err := a.router.Switch(backendURL(decision), decision); err != nil {
+		return MovementDecision{}, &ActuationError{Service: VariantName(decision), Stage: "switch", Err: err}
+	}
+	return decision, nil
+}
+
+// backendURL is the address the service of a decision is reachable at from this host
+func backendURL(decision MovementDecision) string {
+	host := decision.IP
+	if host == "" || host == "0.0.0.0" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, decision.Port)
+}
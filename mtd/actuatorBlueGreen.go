@@ -0,0 +1,233 @@
+package mtd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BlueGreenSettings configures the blue/green actuator
+type BlueGreenSettings struct {
+	// Ports are the side ports a new variant may use when the decided port is still taken by the live one.
+	// UseMoveSpace replaces them with the ports of a reloaded move space.
+	Ports []string
+	// HealthPath is requested on the new variant until it answers without a server error
+	HealthPath string
+	// HealthTimeout is how long the new variant has to become healthy before it is rolled back
+	HealthTimeout time.Duration
+	// HealthInterval is the pause between two health checks
+	HealthInterval time.Duration
+	// DrainTimeout is the longest the old variant keeps running after the switch to finish its in-flight requests
+	DrainTimeout time.Duration
+}
+
+// BlueGreenActuator moves the environment without downtime: the new variant is started next to
+// the live one in its own compose project, health-checked, and only then receives the traffic.
+// The old variant is stopped once drained, in the background. A variant that never becomes healthy
+// is removed and the live one keeps serving.
+type BlueGreenActuator struct {
+	compose  *ComposeActuator
+	router   TrafficSwitch
+	settings BlueGreenSettings
+	client   *http.Client
+
+	mu   sync.Mutex
+	live *deployment
+
+	// ports are the side ports, guarded apart from mu as a movement holds mu until it is deployed
+	portsMu sync.Mutex
+	ports   []string
+
+	// retiring tracks the old variants being drained and stopped
+	retiring  sync.WaitGroup
+	closing   chan struct{} // Closed by Close to stop waiting for drains
+	closeOnce sync.Once
+}
+
+// deployment is a variant running in its own compose project
+type deployment struct {
+	project  string
+	service  string
+	decision MovementDecision
+}
+
+// NewBlueGreenActuator creates a new BlueGreenActuator switching traffic with router
+func NewBlueGreenActuator(compose *ComposeActuator, router TrafficSwitch, settings BlueGreenSettings) (*BlueGreenActuator, error) {
+	if compose == nil || router == nil {
+		return nil, errors.New("compose actuator and traffic switch are required")
+	}
+	if settings.HealthPath == "" {
+		settings.HealthPath = "/"
+	}
+	if settings.HealthTimeout <= 0 {
+		settings.HealthTimeout = 2 * time.Minute
+	}
+	if settings.HealthInterval <= 0 {
+		settings.HealthInterval = 2 * time.Second
+	}
+	return &BlueGreenActuator{
+		compose:  compose,
+		router:   router,
+		settings: settings,
+		client:   &http.Client{Timeout: 2 * time.Second},
+		ports:    settings.Ports,
+		closing:  make(chan struct{}),
+	}, nil
+}
+
+// Apply starts the decided variant next to the live one and moves the traffic to it once healthy.
+// The returned decision records the side port the variant was started on, if any.
+func (a *BlueGreenActuator) Apply(ctx context.Context, decision MovementDecision) (MovementDecision, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// The project of the variant being retired is the one reused next, it must be stopped first
+	a.retiring.Wait()
+
+	next := &deployment{
+		project:  "mtd_blue",
+		service:  a.compose.Service(decision),
+		decision: decision,
+	}
+	if a.live != nil {
+		if a.live.project == next.project {
+			next.project = "mtd_green"
+		}
+		// Both variants run at the same time, so they cannot share a port
+		if a.live.decision.Port == next.decision.Port {
+			port, err := a.sidePort(a.live.decision.Port)
+			if err != nil {
+				return MovementDecision{}, err
+			}
+			log.Printf("Port %s is used by the live variant, starting %s on side port %s", decision.Port, next.service, port)
+			next.decision.Port = port
+			next.decision.Adjustments = append(next.decision.Adjustments, Adjustment{
+				Field:    "port",
+				Proposed: decision.Port,
+				Applied:  port,
+				Reason:   "used by the live variant, started on a side port",
+			})
+		}
+	}
+
+	// Clean up leftovers of a previous failed movement before reusing the project
+	if err := a.compose.compose(ctx, next.project, next.decision, next.service, "down"); err != nil {
+		return MovementDecision{}, err
+	}
+	if err := a.compose.compose(ctx, next.project, next.decision, next.service, "up", "-d", next.service); err != nil {
+		return MovementDecision{}, err
+	}
+	log.Printf("Service %s started in project %s, waiting for it to become healthy", next.service, next.project)
+
+	backend := backendURL(next.decision)
+	if err := a.waitHealthy(ctx, backend); err != nil {
+		a.rollback(next)
+		return MovementDecision{}, &ActuationError{Service: next.service, Stage: "health check", Err: err}
+	}
+
+	drained, err := a.router.Switch(backend, next.decision)
+	if err != nil {
+		a.rollback(next)
+		return MovementDecision{}, &ActuationError{Service: next.service, Stage: "switch", Err: err}
+	}
+
+	old := a.live
+	a.live = next
+	if old != nil {
+		a.retiring.Add(1)
+		go func() {
+			defer a.retiring.Done()
+			a.retire(old, drained)
+		}()
+	}
+	return next.decision, nil
+}
+
+// waitHealthy polls the backend until it answers without a server error or the health timeout expires
+func (a *BlueGreenActuator) waitHealthy(ctx context.Context, backend string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.settings.HealthTimeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, backend+a.settings.HealthPath, nil)
+		if err != nil {
+			return err
+		}
+		res, err := a.client.Do(req)
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode < http.StatusInternalServerError {
+				return nil
+			}
+			err = fmt.Errorf("status %s", res.Status)
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s not healthy after %s: %w", backend, a.settings.HealthTimeout, lastErr)
+		case <-time.After(a.settings.HealthInterval):
+		}
+	}
+}
+
+// rollback removes a variant that never took the traffic
+func (a *BlueGreenActuator) rollback(d *deployment) {
+	log.Printf("Rolling back %s in project %s", d.service, d.project)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := a.compose.compose(ctx, d.project, d.decision, d.service, "down"); err != nil {
+		log.Printf("Error rolling back %s: %v", d.service, err)
+	}
+}
+
+// Close stops waiting for the old variants to drain and returns once they are stopped
+func (a *BlueGreenActuator) Close() error {
+	a.closeOnce.Do(func() { close(a.closing) })
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.retiring.Wait()
+	return nil
+}
+
+// retire waits for the old variant to finish its in-flight requests, at most DrainTimeout, and stops it
+func (a *BlueGreenActuator) retire(d *deployment, drained <-chan struct{}) {
+	select {
+	case <-drained:
+	case <-a.closing:
+	case <-time.After(a.settings.DrainTimeout):
+		log.Printf("Old variant %s still has requests in flight after %s, stopping it anyway", d.service, a.settings.DrainTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := a.compose.compose(ctx, d.project, d.decision, d.service, "down"); err != nil {
+		log.Printf("Error stopping old variant %s: %v", d.service, err)
+		return
+	}
+	log.Printf("Old variant %s in project %s stopped", d.service, d.project)
+}
+
+// UseMoveSpace takes the ports of a reloaded move space as the side ports of the next movements
+func (a *BlueGreenActuator) UseMoveSpace(space Config) {
+	a.portsMu.Lock()
+	defer a.portsMu.Unlock()
+	a.ports = space.Ports
+}
+
+// sidePort returns a configured port other than the one in use
+func (a *BlueGreenActuator) sidePort(inUse string) (string, error) {
+	a.portsMu.Lock()
+	defer a.portsMu.Unlock()
+	for _, port := range a.ports {
+		if port != inUse {
+			return port, nil
+		}
+	}
+	return "", fmt.Errorf("no side port available besides %s", inUse)
+}
@@ -0,0 +1,253 @@
+package mtd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCompose records the docker compose commands as "<project> <command> <port>"
+type fakeCompose struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeCompose) run(ctx context.Context, env []string, name string, args ...string) (string, string, error) {
+	var project, command, port string
+	for i, arg := range args {
+		if arg == "-p" && i+2 < len(args) {
+			project, command = args[i+1], args[i+2]
+		}
+	}
+	for _, variable := range env {
+		if value, ok := strings.CutPrefix(variable, "SELECTED_PORT="); ok {
+			port = value
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, project+" "+command+" "+port)
+	return "", "", nil
+}
+
+// count returns how many recorded commands start with prefix
+func (f *fakeCompose) count(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int
+	for _, call := range f.calls {
+		if strings.HasPrefix(call, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// fakeSwitch records the backends it switches to. The drained channels it returns are closed
+// right away, unless hold is set, then they are closed by release.
+type fakeSwitch struct {
+	hold bool
+
+	mu      sync.Mutex
+	targets []string
+	drains  []chan struct{}
+}
+
+func (f *fakeSwitch) Switch(target string, decision MovementDecision) (<-chan struct{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	drained := make(chan struct{})
+	if !f.hold {
+		close(drained)
+	}
+	f.targets = append(f.targets, target)
+	f.drains = append(f.drains, drained)
+	return drained, nil
+}
+
+// release closes the drained channel returned by the n-th switch
+func (f *fakeSwitch) release(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.drains[n])
+}
+
+func (f *fakeSwitch) switched() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.targets...)
+}
+
+// healthServer is a variant answering its health check with status, it returns the port it listens on
+func healthServer(t *testing.T, status int) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Port()
+}
+
+func newTestBlueGreen(t *testing.T, router TrafficSwitch, settings BlueGreenSettings) (*BlueGreenActuator, *fakeCompose) {
+	t.Helper()
+	compose, err := NewComposeActuator(ComposeSettings{File: "docker-compose.yml"})
+	if err != nil {
+		t.Fatalf("NewComposeActuator: %v", err)
+	}
+	runner := &fakeCompose{}
+	compose.run = runner.run
+	if settings.HealthInterval == 0 {
+		settings.HealthInterval = 10 * time.Millisecond
+	}
+	actuator, err := NewBlueGreenActuator(compose, router, settings)
+	if err != nil {
+		t.Fatalf("NewBlueGreenActuator: %v", err)
+	}
+	t.Cleanup(func() { actuator.Close() })
+	return actuator, runner
+}
+
+// waitFor fails the test if cond does not hold within a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBlueGreenSidePort(t *testing.T) {
+	first, side, reloaded := healthServer(t, http.StatusOK), healthServer(t, http.StatusOK), healthServer(t, http.StatusOK)
+	router := &fakeSwitch{}
+	actuator, _ := newTestBlueGreen(t, router, BlueGreenSettings{Ports: []string{first, side}})
+	ctx := context.Background()
+
+	applied, err := actuator.Apply(ctx, MovementDecision{Port: first, OS: "ubuntu", Language: "go"})
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if applied.Port != first || len(applied.Adjustments) != 0 {
+		t.Errorf("first variant on port %s with adjustments %+v, want the decided port %s", applied.Port, applied.Adjustments, first)
+	}
+
+	// The decided port is taken by the live variant
+	applied, err = actuator.Apply(ctx, MovementDecision{Port: first, OS: "alpine", Language: "python"})
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if applied.Port != side {
+		t.Errorf("second variant on port %s, want the side port %s", applied.Port, side)
+	}
+	if len(applied.Adjustments) != 1 || applied.Adjustments[0].Field != "port" || applied.Adjustments[0].Proposed != first || applied.Adjustments[0].Applied != side {
+		t.Errorf("adjustments = %+v, want the port moved from %s to %s", applied.Adjustments, first, side)
+	}
+
+	// A reloaded move space brings the side ports of the next movements
+	actuator.UseMoveSpace(Config{Ports: []string{side, reloaded}})
+	applied, err = actuator.Apply(ctx, MovementDecision{Port: side, OS: "ubuntu", Language: "go"})
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if applied.Port != reloaded {
+		t.Errorf("variant after the reload on port %s, want the reloaded side port %s", applied.Port, reloaded)
+	}
+
+	want := []string{"http://127.0.0.1:" + first, "http://127.0.0.1:" + side, "http://127.0.0.1:" + reloaded}
+	if got := router.switched(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("switched to %v, want %v", got, want)
+	}
+
+	actuator.UseMoveSpace(Config{Ports: []string{reloaded}})
+	if _, err := actuator.Apply(ctx, MovementDecision{Port: reloaded, OS: "alpine", Language: "python"}); err == nil {
+		t.Error("moving without a free side port succeeded")
+	}
+}
+
+func TestBlueGreenUnhealthyVariant(t *testing.T) {
+	healthy, unhealthy := healthServer(t, http.StatusOK), healthServer(t, http.StatusInternalServerError)
+	router := &fakeSwitch{}
+	actuator, compose := newTestBlueGreen(t, router, BlueGreenSettings{HealthTimeout: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	if _, err := actuator.Apply(ctx, MovementDecision{Port: healthy, OS: "ubuntu", Language: "go"}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	_, err := actuator.Apply(ctx, MovementDecision{Port: unhealthy, OS: "alpine", Language: "python"})
+	var actuationErr *ActuationError
+	if !errors.As(err, &actuationErr) || actuationErr.Stage != "health check" {
+		t.Fatalf("Apply error = %v, want a health check ActuationError", err)
+	}
+
+	// The new variant is removed, the live one is neither stopped nor switched away from
+	if n := compose.count("mtd_green down " + unhealthy); n != 2 {
+		t.Errorf("mtd_green brought down %d times, want 2: before starting it and on rollback", n)
+	}
+	if n := compose.count("mtd_blue down"); n != 1 {
+		t.Errorf("mtd_blue brought down %d times, want only the cleanup before starting it", n)
+	}
+	if got := router.switched(); len(got) != 1 || got[0] != "http://127.0.0.1:"+healthy {
+		t.Errorf("switched to %v, want only the healthy variant", got)
+	}
+
+	// The live variant is still the one the next movement replaces
+	next := healthServer(t, http.StatusOK)
+	if _, err := actuator.Apply(ctx, MovementDecision{Port: next, OS: "alpine", Language: "python"}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if n := compose.count("mtd_green up " + next); n != 1 {
+		t.Errorf("mtd_green started %d times on port %s, want the project next to the live mtd_blue reused", n, next)
+	}
+}
+
+func TestBlueGreenStopsOldVariantAfterDrain(t *testing.T) {
+	blue, green := healthServer(t, http.StatusOK), healthServer(t, http.StatusOK)
+	router := &fakeSwitch{hold: true}
+	actuator, compose := newTestBlueGreen(t, router, BlueGreenSettings{DrainTimeout: time.Minute})
+	ctx := context.Background()
+
+	if _, err := actuator.Apply(ctx, MovementDecision{Port: blue, OS: "ubuntu", Language: "go"}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if _, err := actuator.Apply(ctx, MovementDecision{Port: green, OS: "alpine", Language: "python"}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	// Apply returns while the old variant still serves its in-flight requests
+	time.Sleep(50 * time.Millisecond)
+	if n := compose.count("mtd_blue down"); n != 1 {
+		t.Fatalf("mtd_blue brought down %d times before it drained, want only the cleanup before starting it", n)
+	}
+
+	router.release(1)
+	waitFor(t, "mtd_blue to stop once drained", func() bool { return compose.count("mtd_blue down") == 2 })
+}
+
+func TestBlueGreenStopsOldVariantAfterDrainTimeout(t *testing.T) {
+	blue, green := healthServer(t, http.StatusOK), healthServer(t, http.StatusOK)
+	router := &fakeSwitch{hold: true}
+	actuator, compose := newTestBlueGreen(t, router, BlueGreenSettings{DrainTimeout: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	if _, err := actuator.Apply(ctx, MovementDecision{Port: blue, OS: "ubuntu", Language: "go"}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if _, err := actuator.Apply(ctx, MovementDecision{Port: green, OS: "alpine", Language: "python"}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	// The old variant never drains, it is stopped anyway
+	waitFor(t, "mtd_blue to stop after the drain timeout", func() bool { return compose.count("mtd_blue down") == 2 })
+}
@@ -0,0 +1,106 @@
+package mtd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// ComposeSettings configures the docker compose actuator
+type ComposeSettings struct {
+	// File is the docker compose file declaring one service per OS/language variant
+	File string `json:"compose_file"`
+	// Services maps an OS and a language to the compose service running them.
+	// Pairs missing from the table use the service app_<os>_<language>.
+	Services map[string]map[string]string `json:"services"`
+}
+
+// ComposeActuator moves the environment by replacing the running docker compose service
+type ComposeActuator struct {
+	settings ComposeSettings
+	run      commandRunner
+}
+
+// NewComposeActuator creates a new ComposeActuator
+func NewComposeActuator(settings ComposeSettings) (*ComposeActuator, error) {
+	if settings.File == "" {
+		return nil, errors.New("docker compose file is required")
+	}
+	return &ComposeActuator{
+		settings: settings,
+		run:      runCommand,
+	}, nil
+}
+
+// Service returns the compose service running the OS and language of the decision
+func (a *ComposeActuator) Service(decision MovementDecision) string {
+	if service, ok := a.settings.Services[decision.OS][decision.Language]; ok {
+		return service
+	}
+	return "app_" + decision.OS + "_" + decision.Language
+}
+
+// Apply stops the running services and starts the one matching the decision,
+// exposed on the decided IP and port
+func (a *ComposeActuator) Apply(ctx context.Context, decision MovementDecision) (MovementDecision, error) {
+	service := a.Service(decision)
+
+	if err := a.compose(ctx, "", decision, service, "down"); err != nil {
+		return MovementDecision{}, err
+	}
+	if err := a.compose(ctx, "", decision, service, "up", "-d", service); err != nil {
+		return MovementDecision{}, err
+	}
+
+	log.Printf("Service %s started", service)
+	return decision, nil
+}
+
+// compose runs a docker compose command with the decision exported to the compose file.
+// A non-empty project isolates the containers from the ones of other projects.
+func (a *ComposeActuator) compose(ctx context.Context, project string, decision MovementDecision, service string, args ...string) error {
+	composeArgs := []string{"compose", "-f", a.settings.File}
+	if project != "" {
+		composeArgs = append(composeArgs, "-p", project)
+	}
+	stdout, stderr, err := a.run(ctx, decisionEnv(decision), "docker", append(composeArgs, args...)...)
+	if err != nil {
+		return &ActuationError{
+			Service: service,
+			Stage:   args[0],
+			Stdout:  stdout,
+			Stderr:  stderr,
+			Err:     err,
+		}
+	}
+	return nil
+}
+
+// commandRunner runs a command with env added to the environment of the controller
+type commandRunner func(ctx context.Context, env []string, name string, args ...string) (stdout, stderr string, err error)
+
+// runCommand is the commandRunner executing the command on the host
+func runCommand(ctx context.Context, env []string, name string, args ...string) (string, string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// decisionEnv returns the variables the compose file reads the decision from
+func decisionEnv(decision MovementDecision) []string {
+	return []string{
+		"SELECTED_IP=" + decision.IP,
+		"SELECTED_PORT=" + decision.Port,
+		"SELECTED_FORMAT=" + decision.Format,
+		"SELECTED_LANGUAGE=" + decision.Language,
+		"SELECTED_OS=" + decision.OS,
+	}
+}
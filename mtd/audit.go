@@ -0,0 +1,173 @@
+package mtd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Actuation results recorded in the audit trail
+const (
+	ActuationApplied = "applied"
+	ActuationFailed  = "failed"
+	ActuationSkipped = "dry_run"
+)
+
+// AuditRecord is the audit trail entry of one movement: the decision, what it was based on and how its deployment went
+type AuditRecord struct {
+	Timestamp      time.Time         `json:"timestamp"`
+	Decision       *MovementDecision `json:"decision,omitempty"` // Decision without its reasoning, nil if no decision was reached
+	Metrics        Metrics           `json:"metrics"`
+	Policies       []ScoredPolicy    `json:"policies,omitempty"`
+	PromptHash     string            `json:"prompt_hash,omitempty"` // SHA-256 of the first prompt sent to the advisor
+	AdvisorAnswers []string          `json:"advisor_answers,omitempty"`
+	Recommendation *Recommendation   `json:"recommendation,omitempty"`
+	Adjustments    []Adjustment      `json:"adjustments,omitempty"`
+	Fallback       bool              `json:"fallback"`
+	FallbackReason string            `json:"fallback_reason,omitempty"`
+	Actuation      string            `json:"actuation,omitempty"` // ActuationApplied, ActuationFailed or ActuationSkipped
+	Error          string            `json:"error,omitempty"`
+	DurationMs     int64             `json:"duration_ms"`
+}
+
+// NewAuditRecord builds the audit record of a movement that started at start.
+// decision is nil when the strategy failed, actuation is empty when the decision was not deployed.
+func NewAuditRecord(start time.Time, metrics Metrics, decision *MovementDecision, actuation string, err error) AuditRecord {
+	record := AuditRecord{
+		Timestamp:  start,
+		Metrics:    metrics,
+		Actuation:  actuation,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if decision == nil {
+		return record
+	}
+
+	audited := *decision
+	audited.Reasoning = nil
+	audited.Adjustments = nil
+	record.Decision = &audited
+	record.Adjustments = decision.Adjustments
+
+	if reasoning := decision.Reasoning; reasoning != nil {
+		record.Policies = reasoning.Policies
+		if reasoning.Prompt != "" {
+			sum := sha256.Sum256([]byte(reasoning.Prompt))
+			record.PromptHash = hex.EncodeToString(sum[:])
+		}
+		record.AdvisorAnswers = reasoning.AdvisorAnswers
+		record.Recommendation = reasoning.Recommendation
+		record.Fallback = reasoning.FallbackReason != ""
+		record.FallbackReason = reasoning.FallbackReason
+	}
+	return record
+}
+
+// AuditLog persists audit records. Records are only ever appended.
+type AuditLog interface {
+	Record(ctx context.Context, record AuditRecord) error
+}
+
+// Ensure the audit logs satisfy the AuditLog interface
+var (
+	_ AuditLog = (*FileAuditLog)(nil)
+	_ AuditLog = (*ElasticAuditLog)(nil)
+	_ AuditLog = MultiAuditLog(nil)
+)
+
+// FileAuditLog appends audit records to a JSON Lines file
+type FileAuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFileAuditLog opens the JSON Lines file at path for appending, creating it if needed
+func OpenFileAuditLog(path string) (*FileAuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return &FileAuditLog{file: file}, nil
+}
+
+// Record writes the record as a single line and syncs it to disk
+func (l *FileAuditLog) Record(ctx context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding audit record: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(line); err != nil {
+		return fmt.Errorf("writing audit record: %w", err)
+	}
+	return l.file.Sync()
+}
+
+// Close closes the underlying file
+func (l *FileAuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// ElasticAuditLog indexes audit records in an Elasticsearch index
+type ElasticAuditLog struct {
+	es    *elasticsearch.Client
+	index string
+}
+
+// NewElasticAuditLog creates an audit log writing to the given index
+func NewElasticAuditLog(es *elasticsearch.Client, index string) *ElasticAuditLog {
+	return &ElasticAuditLog{es: es, index: index}
+}
+
+// Record indexes the record as a new document
+func (l *ElasticAuditLog) Record(ctx context.Context, record AuditRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding audit record: %w", err)
+	}
+
+	res, err := l.es.Index(l.index, bytes.NewReader(body),
+		l.es.Index.WithContext(ctx),
+		// Never overwrite an existing record
+		l.es.Index.WithOpType("create"),
+	)
+	if err != nil {
+		return fmt.Errorf("indexing audit record: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error indexing audit record in %s: %s", l.index, res.String())
+	}
+	return nil
+}
+
+// MultiAuditLog writes every record to all of its audit logs
+type MultiAuditLog []AuditLog
+
+// Record writes the record to every audit log, even when some of them fail
+func (m MultiAuditLog) Record(ctx context.Context, record AuditRecord) error {
+	var errs []error
+	for _, l := range m {
+		if err := l.Record(ctx, record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,162 @@
+package mtd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readAuditLog decodes every line of the JSON Lines file at path
+func readAuditLog(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %d is not a JSON record: %v", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestNewAuditRecord(t *testing.T) {
+	start := time.Now().Add(-time.Second)
+	prompt := "Current metrics: error_rate 0.2"
+	decision := &MovementDecision{
+		Port: "8081", OS: "alpine", Language: "python", Format: "xml", Strategy: Weighted,
+		Adjustments: []Adjustment{{Field: "os", Proposed: "windows", Applied: "alpine", Reason: "not configured"}},
+		Reasoning: &Reasoning{
+			Prompt:         prompt,
+			AdvisorAnswers: []string{"not json"},
+			Fallback:       FallbackUnparsableAnswer,
+			FallbackReason: "no valid recommendation in 1 answer",
+		},
+	}
+	record := NewAuditRecord(start, testMetrics, decision, ActuationFailed, errors.New("compose up failed"))
+
+	sum := sha256.Sum256([]byte(prompt))
+	if record.PromptHash != hex.EncodeToString(sum[:]) {
+		t.Errorf("prompt hash = %s, want the SHA-256 of the prompt", record.PromptHash)
+	}
+	if !record.Fallback || record.FallbackReason != "no valid recommendation in 1 answer" {
+		t.Errorf("fallback = %v %q, want the fallback reason", record.Fallback, record.FallbackReason)
+	}
+	if record.Actuation != ActuationFailed || record.Error != "compose up failed" {
+		t.Errorf("actuation = %q with error %q, want the failed actuation", record.Actuation, record.Error)
+	}
+	if record.DurationMs < 1000 {
+		t.Errorf("duration = %d ms, want at least the second since start", record.DurationMs)
+	}
+	if record.Decision == nil || record.Decision.Reasoning != nil || record.Decision.Adjustments != nil {
+		t.Errorf("decision = %+v, want it without its reasoning and adjustments", record.Decision)
+	}
+	if len(record.Adjustments) != 1 || len(record.AdvisorAnswers) != 1 {
+		t.Errorf("adjustments %+v and answers %q, want them moved to the record", record.Adjustments, record.AdvisorAnswers)
+	}
+	if decision.Reasoning == nil || decision.Adjustments == nil {
+		t.Error("the audited decision was modified")
+	}
+
+	// A strategy failure has no decision
+	record = NewAuditRecord(start, testMetrics, nil, "", errors.New("no configuration"))
+	if record.Decision != nil || record.PromptHash != "" || record.Fallback || record.Actuation != "" {
+		t.Errorf("record without decision = %+v", record)
+	}
+}
+
+func TestFileAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	ctx := context.Background()
+	decision := &MovementDecision{
+		Port: "8081", OS: "alpine", Language: "python", Format: "xml", Strategy: Weighted,
+		Reasoning: &Reasoning{Prompt: "prompt", Fallback: FallbackAdvisorError, FallbackReason: "advisor unreachable"},
+	}
+
+	first, err := OpenFileAuditLog(path)
+	if err != nil {
+		t.Fatalf("OpenFileAuditLog: %v", err)
+	}
+	if err := first.Record(ctx, NewAuditRecord(time.Now(), testMetrics, decision, ActuationApplied, nil)); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Opening the log again, as a restarted controller does, appends to it
+	second, err := OpenFileAuditLog(path)
+	if err != nil {
+		t.Fatalf("OpenFileAuditLog: %v", err)
+	}
+	defer second.Close()
+	if err := second.Record(ctx, NewAuditRecord(time.Now(), testMetrics, nil, "", errors.New("deciding movement: no configuration"))); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	records := readAuditLog(t, path)
+	if len(records) != 2 {
+		t.Fatalf("audit log holds %d records, want 2 appended", len(records))
+	}
+	sum := sha256.Sum256([]byte("prompt"))
+	want := map[string]interface{}{
+		"prompt_hash":     hex.EncodeToString(sum[:]),
+		"fallback":        true,
+		"fallback_reason": "advisor unreachable",
+		"actuation":       ActuationApplied,
+	}
+	for key, value := range want {
+		if records[0][key] != value {
+			t.Errorf("first record %s = %v, want %v", key, records[0][key], value)
+		}
+	}
+	if _, ok := records[0]["prompt"]; ok {
+		t.Error("the first record holds the prompt itself")
+	}
+	if records[1]["error"] != "deciding movement: no configuration" || records[1]["decision"] != nil {
+		t.Errorf("second record = %v, want the failure without decision", records[1])
+	}
+}
+
+// failingAuditLog fails every record
+type failingAuditLog struct{ err error }
+
+func (l failingAuditLog) Record(ctx context.Context, record AuditRecord) error {
+	return l.err
+}
+
+func TestMultiAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	file, err := OpenFileAuditLog(path)
+	if err != nil {
+		t.Fatalf("OpenFileAuditLog: %v", err)
+	}
+	defer file.Close()
+
+	unreachable := errors.New("elasticsearch unreachable")
+	logs := MultiAuditLog{failingAuditLog{unreachable}, file}
+	err = logs.Record(context.Background(), NewAuditRecord(time.Now(), testMetrics, nil, "", nil))
+	if !errors.Is(err, unreachable) {
+		t.Errorf("Record error = %v, want the failing log's error", err)
+	}
+	if records := readAuditLog(t, path); len(records) != 1 {
+		t.Errorf("file audit log holds %d records, want the record written despite the other log failing", len(records))
+	}
+}
@@ -7,38 +7,41 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/elastic/go-elasticsearch/v8"
 )
 
-type Criteria struct {
-	ResponseTimeMs     float64 `json:"response_time_ms"`
-	ErrorRate          float64 `json:"error_rate"`
-	VulnerabilityCount int     `json:"vulnerability_count"`
-	IntrusionAttempts  int     `json:"intrusion_attempts"`
+// ElasticsearchSettings holds how to reach Elasticsearch
+type ElasticsearchSettings struct {
+	URL      string
+	User     string
+	Password string
 }
 
-type RecommendedActions struct {
-	SwitchLanguage string `json:"switch_language"`
-	SwitchFormat   string `json:"switch_format"`
-	SwitchOS       string `json:"switch_os"`
-	RotateIP       bool   `json:"rotate_ip"`
+// ElasticsearchSettingsFromEnv reads the settings from ELASTICSEARCH_URL, ELASTICSEARCH_USER and ELASTICSEARCH_PASSWORD
+func ElasticsearchSettingsFromEnv() ElasticsearchSettings {
+	return ElasticsearchSettings{
+		URL:      os.Getenv("ELASTICSEARCH_URL"),
+		User:     os.Getenv("ELASTICSEARCH_USER"),
+		Password: os.Getenv("ELASTICSEARCH_PASSWORD"),
+	}
 }
 
-type Policy struct {
-	PolicyName         string             `json:"policy_name"`
-	Criteria           Criteria           `json:"criteria"`
-	RecommendedActions RecommendedActions `json:"recommended_actions"`
+// InitializeElasticsearch initializes and returns an Elasticsearch client configured from the environment
+func InitializeElasticsearch() (*elasticsearch.Client, error) {
+	return ConnectElasticsearch(ElasticsearchSettingsFromEnv())
 }
 
-// InitializeElasticsearch initializes and returns an Elasticsearch client
-func InitializeElasticsearch() (*elasticsearch.Client, error) {
+// ConnectElasticsearch creates an Elasticsearch client and checks it can reach the cluster
+func ConnectElasticsearch(settings ElasticsearchSettings) (*elasticsearch.Client, error) {
 	cfg := elasticsearch.Config{
 		Addresses: []string{
-			os.Getenv("ELASTICSEARCH_URL"),
+			settings.URL,
 		},
-		Username: os.Getenv("ELASTICSEARCH_USER"),
-		Password: os.Getenv("ELASTICSEARCH_PASSWORD"),
+		Username: settings.User,
+		Password: settings.Password,
 	}
 
 	es, err := elasticsearch.NewClient(cfg)
@@ -60,51 +63,64 @@ func InitializeElasticsearch() (*elasticsearch.Client, error) {
 	return es, nil
 }
 
-// FetchKnowledge fetches relevant knowledge based on current metrics
-func ElasticSearch(es *elasticsearch.Client, metrics Metrics) ([]Policy, error) {
-	log.Printf(`
-	Searching on Elasticsearch for:
-		response time: %f
-		error rate: %f
-		vulnerability count: %d
-		intrusion attempts: %d
-	`, metrics.QualityOfService.ResponseTimeMs, metrics.QualityOfService.ErrorRate,
-		metrics.SecurityMetrics.VulnerabilityCount, metrics.SecurityMetrics.IntrusionAttempts)
+// ElasticKnowledgeBase is a KnowledgeBase backed by an Elasticsearch index
+type ElasticKnowledgeBase struct {
+	es    *elasticsearch.Client
+	index string
+
+	mu     sync.Mutex
+	mapped map[string]bool // Criteria of registered metrics already mapped in the index
+}
+
+// NewElasticKnowledgeBase creates a KnowledgeBase that searches the given index
+func NewElasticKnowledgeBase(es *elasticsearch.Client, index string) *ElasticKnowledgeBase {
+	return &ElasticKnowledgeBase{
+		es:     es,
+		index:  index,
+		mapped: make(map[string]bool),
+	}
+}
+
+// Search fetches relevant knowledge based on current metrics
+func (kb *ElasticKnowledgeBase) Search(ctx context.Context, metrics Metrics, weights Weights) ([]ScoredPolicy, error) {
+	// Rank every policy by its weighted gauss decay distance to the current metrics,
+	// so close values score high instead of only exact matches
+	criteria := similarityCriteria(metrics, weights)
+	var searched strings.Builder
+	for _, c := range criteria {
+		fmt.Fprintf(&searched, "\t\t%s: %g\n", c.field, c.origin)
+	}
+	log.Printf("\n\tSearching on Elasticsearch for:\n%s", searched.String())
+	if err := kb.mapCriteria(ctx, criteria); err != nil {
+		return nil, err
+	}
+	functions := make([]map[string]interface{}, 0, len(criteria))
+	var totalWeight float64
+	for _, c := range criteria {
+		if c.weight <= 0 {
+			continue
+		}
+		totalWeight += c.weight
+		functions = append(functions, map[string]interface{}{
+			"gauss": map[string]interface{}{
+				"criteria." + c.field: map[string]interface{}{
+					"origin": c.origin,
+					"scale":  c.scale,
+					"decay":  similarityDecay,
+				},
+			},
+			"weight": c.weight,
+		})
+	}
 
 	query := map[string]interface{}{
-		"size": 5,
+		"size": knowledgeSearchSize,
 		"query": map[string]interface{}{
-			"bool": map[string]interface{}{
-				"should": []map[string]interface{}{
-					{
-						"multi_match": map[string]interface{}{
-							"query":  metrics.QualityOfService.ResponseTimeMs,
-							"fields": []string{"criteria.response_time_ms"},
-							"type":   "best_fields",
-						},
-					},
-					{
-						"multi_match": map[string]interface{}{
-							"query":  metrics.QualityOfService.ErrorRate,
-							"fields": []string{"criteria.error_rate"},
-							"type":   "best_fields",
-						},
-					},
-					{
-						"multi_match": map[string]interface{}{
-							"query":  metrics.SecurityMetrics.VulnerabilityCount,
-							"fields": []string{"criteria.vulnerability_count"},
-							"type":   "best_fields",
-						},
-					},
-					{
-						"multi_match": map[string]interface{}{
-							"query":  metrics.SecurityMetrics.IntrusionAttempts,
-							"fields": []string{"criteria.intrusion_attempts"},
-							"type":   "best_fields",
-						},
-					},
-				},
+			"function_score": map[string]interface{}{
+				"query":      map[string]interface{}{"match_all": map[string]interface{}{}},
+				"functions":  functions,
+				"score_mode": "sum",
+				"boost_mode": "replace",
 			},
 		},
 	}
@@ -114,12 +130,12 @@ func ElasticSearch(es *elasticsearch.Client, metrics Metrics) ([]Policy, error)
 		return nil, err
 	}
 
-	res, err := es.Search(
-		es.Search.WithContext(context.Background()),
-		es.Search.WithIndex(os.Getenv("ELASTICSEARCH_INDEX")),
-		es.Search.WithBody(&buf),
-		es.Search.WithTrackTotalHits(true),
-		es.Search.WithPretty(),
+	res, err := kb.es.Search(
+		kb.es.Search.WithContext(ctx),
+		kb.es.Search.WithIndex(kb.index),
+		kb.es.Search.WithBody(&buf),
+		kb.es.Search.WithTrackTotalHits(true),
+		kb.es.Search.WithPretty(),
 	)
 	if err != nil {
 		return nil, err
@@ -137,6 +153,7 @@ func ElasticSearch(es *elasticsearch.Client, metrics Metrics) ([]Policy, error)
 				Value int `json:"value"`
 			} `json:"total"`
 			Hits []struct {
+				Score  float64                `json:"_score"`
 				Source map[string]interface{} `json:"_source"`
 			} `json:"hits"`
 		} `json:"hits"`
@@ -151,7 +168,7 @@ func ElasticSearch(es *elasticsearch.Client, metrics Metrics) ([]Policy, error)
 	}
 
 	// Load all retrieved policies
-	policies := make([]Policy, 0)
+	policies := make([]ScoredPolicy, 0)
 	for _, hit := range esResponse.Hits.Hits {
 		var policy Policy
 		sourceBytes, err := json.Marshal(hit.Source)
@@ -161,8 +178,84 @@ func ElasticSearch(es *elasticsearch.Client, metrics Metrics) ([]Policy, error)
 		if err := json.Unmarshal(sourceBytes, &policy); err != nil {
 			return nil, err
 		}
-		policies = append(policies, policy)
+		// Normalize the summed function scores to a 0..1 similarity, 0 when no criterion
+		// was weighted, like the memory knowledge base
+		var score float64
+		if totalWeight > 0 {
+			score = hit.Score / totalWeight
+		}
+		policies = append(policies, ScoredPolicy{
+			Policy: policy,
+			Score:  score,
+		})
 	}
 
 	return policies, nil
 }
+
+// mapCriteria maps the criteria of the registered metrics that are not built in as floats, unless already done.
+// Elasticsearch rejects a decay function on a field missing from the mapping, which is the case of a metric
+// until a policy using it is indexed.
+func (kb *ElasticKnowledgeBase) mapCriteria(ctx context.Context, criteria []criterion) error {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	properties := make(map[string]interface{})
+	for _, c := range criteria {
+		if !IsBuiltinMetric(c.field) && !kb.mapped[c.field] {
+			properties[c.field] = map[string]interface{}{"type": "float"}
+		}
+	}
+	if len(properties) == 0 {
+		return nil
+	}
+
+	mapping := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"criteria": map[string]interface{}{"properties": properties},
+		},
+	}
+	body, err := json.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+	res, err := kb.es.Indices.PutMapping([]string{kb.index}, bytes.NewReader(body),
+		kb.es.Indices.PutMapping.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error mapping the criteria of index %s: %s", kb.index, res.String())
+	}
+	for field := range properties {
+		kb.mapped[field] = true
+	}
+	return nil
+}
+
+// Learn indexes the policy under its document ID, replacing any previous version of it
+func (kb *ElasticKnowledgeBase) Learn(ctx context.Context, policy Policy) error {
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid policy %s: %w", policy.PolicyName, err)
+	}
+	body, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	res, err := kb.es.Index(kb.index, bytes.NewReader(body),
+		kb.es.Index.WithContext(ctx),
+		kb.es.Index.WithDocumentID(policy.DocumentID()),
+		kb.es.Index.WithRefresh("true"),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error indexing policy %s: %s", policy.PolicyName, res.String())
+	}
+	return nil
+}
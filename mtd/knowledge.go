@@ -0,0 +1,165 @@
+package mtd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+)
+
+const (
+	// knowledgeSearchSize is the maximum number of policies returned by a search
+	knowledgeSearchSize = 5
+	// similarityDecay is the similarity of a criterion that is exactly one scale away from the current metrics
+	similarityDecay = 0.5
+)
+
+// Criteria describes the metrics under which a policy applies, by metric name
+type Criteria map[string]float64
+
+// MarshalJSON writes the criteria in catalog order, so that a policy always has the same DocumentID
+func (c Criteria) MarshalJSON() ([]byte, error) {
+	if c == nil {
+		return []byte("null"), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range sortedMetricNames(c) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(c[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// RecommendedActions describes the movement an SME recommends for a policy
+type RecommendedActions struct {
+	SwitchLanguage string `json:"switch_language"`
+	SwitchFormat   string `json:"switch_format"`
+	SwitchOS       string `json:"switch_os"`
+	RotateIP       bool   `json:"rotate_ip"`
+}
+
+// Policy sources
+const (
+	// PolicySME marks a policy written by a subject matter expert, the default
+	PolicySME = "sme"
+	// PolicyLearned marks a policy learned from the outcome of a previous movement
+	PolicyLearned = "learned"
+)
+
+// Policy is a knowledge base record of a previous SME decision, or of a movement that improved the metrics
+type Policy struct {
+	ID                 string             `json:"id,omitempty"`
+	PolicyName         string             `json:"policy_name"`
+	Criteria           Criteria           `json:"criteria"`
+	RecommendedActions RecommendedActions `json:"recommended_actions"`
+	Source             string             `json:"source,omitempty"`        // PolicySME when empty, or PolicyLearned
+	OutcomeScore       float64            `json:"outcome_score,omitempty"` // Improvement observed after a learned movement, from 0 to 1
+}
+
+// ScoredPolicy is a policy retrieved from the knowledge base along with its similarity
+// to the current metrics, from 0 (unrelated) to 1 (identical)
+type ScoredPolicy struct {
+	Policy Policy  `json:"policy"`
+	Score  float64 `json:"score"`
+}
+
+// KnowledgeBase retrieves the SME policies that best match the current metrics,
+// ranked from the most to the least similar, each metric category counting as much as its weight
+type KnowledgeBase interface {
+	Search(ctx context.Context, metrics Metrics, weights Weights) ([]ScoredPolicy, error)
+}
+
+// KnowledgeWriter adds policies to the knowledge base, replacing any policy with the same document ID
+type KnowledgeWriter interface {
+	Learn(ctx context.Context, policy Policy) error
+}
+
+var (
+	_ KnowledgeBase   = (*ElasticKnowledgeBase)(nil)
+	_ KnowledgeBase   = (*MemoryKnowledgeBase)(nil)
+	_ KnowledgeWriter = (*ElasticKnowledgeBase)(nil)
+	_ KnowledgeWriter = (*MemoryKnowledgeBase)(nil)
+)
+
+// LoadPolicies reads policies from a knowledge.json file
+func LoadPolicies(filepath string) ([]Policy, error) {
+	var policies []Policy
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &policies)
+	return policies, err
+}
+
+// criterion describes how one policy criterion is compared with the current metrics
+type criterion struct {
+	field  string
+	origin float64
+	// scale is the distance from origin at which the criterion similarity drops to similarityDecay
+	scale  float64
+	weight float64
+}
+
+// similarityCriteria returns the criteria used to rank policies against the current metrics: one per
+// registered metric that has a value, weighted by the weight of its category
+func similarityCriteria(metrics Metrics, weights Weights) []criterion {
+	if weights.QualityOfService <= 0 && weights.SecurityMetrics <= 0 && weights.AssetValue <= 0 {
+		weights = Weights{QualityOfService: 1, SecurityMetrics: 1, AssetValue: 1}
+	}
+
+	var criteria []criterion
+	for _, def := range MetricDefinitions() {
+		value, ok := metrics.Value(def.Name)
+		if !ok {
+			continue
+		}
+		criteria = append(criteria, criterion{
+			field:  def.Name,
+			origin: value,
+			scale:  def.Scale,
+			weight: weights.Of(def.Category),
+		})
+	}
+	return criteria
+}
+
+// similarity returns the weighted gauss decay similarity of a policy to the current metrics.
+// It matches the Elasticsearch function_score so both knowledge bases rank policies alike.
+func similarity(policy Criteria, criteria []criterion) float64 {
+	var score, totalWeight float64
+	for _, c := range criteria {
+		if c.weight <= 0 {
+			continue
+		}
+		totalWeight += c.weight
+		value, ok := policy[c.field]
+		if !ok {
+			// Elasticsearch decay functions score documents without a mapped field 1,
+			// and the elasticsearch knowledge base maps the criteria of every metric it searches on
+			score += c.weight
+			continue
+		}
+		distance := (value - c.origin) / c.scale
+		score += c.weight * math.Pow(similarityDecay, distance*distance)
+	}
+	if totalWeight == 0 {
+		return 0
+	}
+	return score / totalWeight
+}
@@ -0,0 +1,224 @@
+package mtd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// knowledgeMapping is the Elasticsearch mapping of the knowledge base index
+const knowledgeMapping = `{
+  "mappings": {
+    "dynamic_templates": [
+      {
+        "criteria": {
+          "path_match": "criteria.*",
+          "mapping": { "type": "float" }
+        }
+      }
+    ],
+    "properties": {
+      "id": { "type": "keyword" },
+      "policy_name": { "type": "text" },
+      "criteria": {
+        "properties": {
+          "response_time_ms": { "type": "float" },
+          "error_rate": { "type": "float" },
+          "vulnerability_count": { "type": "integer" },
+          "intrusion_attempts": { "type": "integer" }
+        }
+      },
+      "recommended_actions": {
+        "properties": {
+          "switch_language": { "type": "keyword" },
+          "switch_format": { "type": "keyword" },
+          "switch_os": { "type": "keyword" },
+          "rotate_ip": { "type": "boolean" }
+        }
+      },
+      "source": { "type": "keyword" },
+      "outcome_score": { "type": "float" }
+    }
+  }
+}`
+
+// IngestError describes a policy that could not be indexed
+type IngestError struct {
+	Position   int    // Position of the policy in the ingested list
+	ID         string // Document ID of the policy
+	PolicyName string
+	Reason     string
+}
+
+// IngestReport summarizes a knowledge base ingestion
+type IngestReport struct {
+	Indexed int
+	Failed  []IngestError
+}
+
+// Validate checks that a policy matches the knowledge base schema
+func (p Policy) Validate() error {
+	var problems []string
+	if strings.TrimSpace(p.PolicyName) == "" {
+		problems = append(problems, "policy_name is empty")
+	}
+	for _, name := range sortedMetricNames(p.Criteria) {
+		value := p.Criteria[name]
+		def, ok := LookupMetric(name)
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("criteria.%s is not a registered metric", name))
+		case def.Unit == "ratio" && (value < 0 || value > 1):
+			problems = append(problems, fmt.Sprintf("criteria.%s is not between 0 and 1", name))
+		case value < 0:
+			problems = append(problems, fmt.Sprintf("criteria.%s is negative", name))
+		}
+	}
+	if p.RecommendedActions.SwitchLanguage == "" {
+		problems = append(problems, "recommended_actions.switch_language is empty")
+	}
+	if p.RecommendedActions.SwitchFormat == "" {
+		problems = append(problems, "recommended_actions.switch_format is empty")
+	}
+	if p.RecommendedActions.SwitchOS == "" {
+		problems = append(problems, "recommended_actions.switch_os is empty")
+	}
+	if p.Source != "" && p.Source != PolicySME && p.Source != PolicyLearned {
+		problems = append(problems, fmt.Sprintf("source is not %s or %s", PolicySME, PolicyLearned))
+	}
+	if p.OutcomeScore < 0 || p.OutcomeScore > 1 {
+		problems = append(problems, "outcome_score is not between 0 and 1")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// DocumentID returns the policy ID, or a hash of its content when it has none,
+// so ingesting the same knowledge twice overwrites documents instead of duplicating them
+func (p Policy) DocumentID() string {
+	if p.ID != "" {
+		return p.ID
+	}
+	data, _ := json.Marshal(p)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:12])
+}
+
+// CreateKnowledgeIndex creates the knowledge base index with its mapping, unless it already exists
+func CreateKnowledgeIndex(ctx context.Context, es *elasticsearch.Client, index string) error {
+	res, err := es.Indices.Exists([]string{index}, es.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == 200 {
+		log.Printf("Index %s already exists", index)
+		return nil
+	}
+
+	res, err = es.Indices.Create(index,
+		es.Indices.Create.WithContext(ctx),
+		es.Indices.Create.WithBody(strings.NewReader(knowledgeMapping)),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error creating index %s: %s", index, res.String())
+	}
+
+	log.Printf("Index %s created", index)
+	return nil
+}
+
+// IngestKnowledge validates the policies and bulk indexes the valid ones using their document IDs.
+// Invalid policies and documents rejected by Elasticsearch are listed in the report.
+func IngestKnowledge(ctx context.Context, es *elasticsearch.Client, index string, policies []Policy) (IngestReport, error) {
+	var report IngestReport
+	var body bytes.Buffer
+	positions := make(map[string]int)
+
+	for i, policy := range policies {
+		id := policy.DocumentID()
+		if err := policy.Validate(); err != nil {
+			report.Failed = append(report.Failed, IngestError{Position: i, ID: id, PolicyName: policy.PolicyName, Reason: err.Error()})
+			continue
+		}
+		if first, ok := positions[id]; ok {
+			report.Failed = append(report.Failed, IngestError{Position: i, ID: id, PolicyName: policy.PolicyName, Reason: fmt.Sprintf("duplicate of policy at position %d", first)})
+			continue
+		}
+		positions[id] = i
+		policy.ID = id
+
+		action := map[string]interface{}{
+			"index": map[string]interface{}{"_index": index, "_id": id},
+		}
+		if err := json.NewEncoder(&body).Encode(action); err != nil {
+			return report, err
+		}
+		if err := json.NewEncoder(&body).Encode(policy); err != nil {
+			return report, err
+		}
+	}
+
+	if len(positions) == 0 {
+		return report, nil
+	}
+
+	res, err := es.Bulk(&body,
+		es.Bulk.WithContext(ctx),
+		es.Bulk.WithRefresh("true"),
+	)
+	if err != nil {
+		return report, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return report, fmt.Errorf("error ingesting knowledge: %s", res.String())
+	}
+
+	var bulkResponse struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&bulkResponse); err != nil {
+		return report, err
+	}
+
+	for _, item := range bulkResponse.Items {
+		for _, result := range item {
+			if result.Status >= 200 && result.Status < 300 {
+				report.Indexed++
+				continue
+			}
+			position := positions[result.ID]
+			report.Failed = append(report.Failed, IngestError{
+				Position:   position,
+				ID:         result.ID,
+				PolicyName: policies[position].PolicyName,
+				Reason:     fmt.Sprintf("%s: %s", result.Error.Type, result.Error.Reason),
+			})
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,86 @@
+package mtd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// MemoryKnowledgeBase is an in-process KnowledgeBase that keeps every policy in memory
+// and returns the nearest neighbours of the current metrics. It needs no external service.
+type MemoryKnowledgeBase struct {
+	mu       sync.RWMutex
+	policies []Policy
+}
+
+// NewMemoryKnowledgeBase creates a KnowledgeBase from the given policies
+func NewMemoryKnowledgeBase(policies []Policy) *MemoryKnowledgeBase {
+	return &MemoryKnowledgeBase{
+		policies: policies,
+	}
+}
+
+// LoadMemoryKnowledgeBase creates a KnowledgeBase from a knowledge.json file
+func LoadMemoryKnowledgeBase(filepath string) (*MemoryKnowledgeBase, error) {
+	policies, err := LoadPolicies(filepath)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded %d policies from %s", len(policies), filepath)
+	return NewMemoryKnowledgeBase(policies), nil
+}
+
+// Search returns the policies whose criteria are closest to the current metrics
+func (kb *MemoryKnowledgeBase) Search(ctx context.Context, metrics Metrics, weights Weights) ([]ScoredPolicy, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	if len(kb.policies) == 0 {
+		return nil, errors.New("no knowledge data found")
+	}
+
+	criteria := similarityCriteria(metrics, weights)
+	matches := make([]ScoredPolicy, 0, len(kb.policies))
+	for _, policy := range kb.policies {
+		matches = append(matches, ScoredPolicy{
+			Policy: policy,
+			Score:  similarity(policy.Criteria, criteria),
+		})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+
+	if len(matches) > knowledgeSearchSize {
+		matches = matches[:knowledgeSearchSize]
+	}
+	return matches, nil
+}
+
+// Learn adds the policy, replacing the policy with the same document ID.
+// Learned policies are kept in memory only, they are lost when the process exits.
+func (kb *MemoryKnowledgeBase) Learn(ctx context.Context, policy Policy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid policy %s: %w", policy.PolicyName, err)
+	}
+
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	id := policy.DocumentID()
+	for i, existing := range kb.policies {
+		if existing.DocumentID() == id {
+			kb.policies[i] = policy
+			return nil
+		}
+	}
+	kb.policies = append(kb.policies, policy)
+	return nil
+}
@@ -0,0 +1,198 @@
+package mtd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// mustMetrics decodes metrics written as in metrics.json
+func mustMetrics(data string) Metrics {
+	var metrics Metrics
+	if err := json.Unmarshal([]byte(data), &metrics); err != nil {
+		panic(err)
+	}
+	return metrics
+}
+
+// testMetrics are the current metrics the knowledge base tests search with
+var testMetrics = mustMetrics(`{
+	"quality_of_service": {"response_time_ms": 100, "error_rate": 0.01},
+	"security_metrics": {"vulnerability_count": 5, "intrusion_attempts": 10}
+}`)
+
+// testPolicy returns a policy with the criteria written as in knowledge.json
+func testPolicy(name, criteria string) Policy {
+	var policy Policy
+	data := fmt.Sprintf(`{
+		"policy_name": %q,
+		"criteria": %s,
+		"recommended_actions": {"switch_language": "go", "switch_format": "json", "switch_os": "ubuntu"}
+	}`, name, criteria)
+	if err := json.Unmarshal([]byte(data), &policy); err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+func TestMemoryKnowledgeBaseSearchScores(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria string
+		weights  Weights
+		want     float64
+	}{
+		{
+			name:     "identical criteria",
+			criteria: `{"response_time_ms": 100, "error_rate": 0.01}`,
+			weights:  Weights{QualityOfService: 1},
+			want:     1,
+		},
+		{
+			name:     "one scale away on one of two criteria",
+			criteria: `{"response_time_ms": 200, "error_rate": 0.01}`,
+			weights:  Weights{QualityOfService: 1},
+			want:     (similarityDecay + 1) / 2,
+		},
+		{
+			name:     "two scales away",
+			criteria: `{"response_time_ms": 300, "error_rate": 0.01}`,
+			weights:  Weights{QualityOfService: 1},
+			want:     (math.Pow(similarityDecay, 4) + 1) / 2,
+		},
+		{
+			name:     "missing criterion scores as a match",
+			criteria: `{"response_time_ms": 100}`,
+			weights:  Weights{QualityOfService: 1},
+			want:     1,
+		},
+		{
+			name:     "categories without weight are ignored",
+			criteria: `{"response_time_ms": 100, "error_rate": 0.01, "vulnerability_count": 500}`,
+			weights:  Weights{QualityOfService: 1},
+			want:     1,
+		},
+		{
+			name:     "no weights count every category alike",
+			criteria: `{"response_time_ms": 100, "error_rate": 0.01, "vulnerability_count": 15, "intrusion_attempts": 10}`,
+			weights:  Weights{},
+			want:     (1 + 1 + similarityDecay + 1) / 4,
+		},
+		{
+			name:     "weights scale the categories",
+			criteria: `{"response_time_ms": 200, "error_rate": 0.01, "vulnerability_count": 5, "intrusion_attempts": 10}`,
+			weights:  Weights{QualityOfService: 0.25, SecurityMetrics: 0.75},
+			want:     (0.25*similarityDecay + 0.25 + 0.75 + 0.75) / 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb := NewMemoryKnowledgeBase([]Policy{testPolicy(tt.name, tt.criteria)})
+			matches, err := kb.Search(context.Background(), testMetrics, tt.weights)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if len(matches) != 1 {
+				t.Fatalf("got %d matches, want 1", len(matches))
+			}
+			if got := matches[0].Score; math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("score = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryKnowledgeBaseSearchRanking(t *testing.T) {
+	policies := []Policy{
+		testPolicy("far", `{"response_time_ms": 900, "error_rate": 0.2, "vulnerability_count": 60, "intrusion_attempts": 200}`),
+		testPolicy("exact", `{"response_time_ms": 100, "error_rate": 0.01, "vulnerability_count": 5, "intrusion_attempts": 10}`),
+		testPolicy("slow", `{"response_time_ms": 250, "error_rate": 0.01, "vulnerability_count": 5, "intrusion_attempts": 10}`),
+		testPolicy("close", `{"response_time_ms": 120, "error_rate": 0.01, "vulnerability_count": 5, "intrusion_attempts": 10}`),
+		testPolicy("attacked", `{"response_time_ms": 100, "error_rate": 0.01, "vulnerability_count": 5, "intrusion_attempts": 200}`),
+		testPolicy("vulnerable", `{"response_time_ms": 100, "error_rate": 0.01, "vulnerability_count": 20, "intrusion_attempts": 10}`),
+		testPolicy("failing", `{"response_time_ms": 100, "error_rate": 0.3, "vulnerability_count": 5, "intrusion_attempts": 10}`),
+	}
+
+	tests := []struct {
+		name    string
+		weights Weights
+		want    []string
+	}{
+		{
+			name:    "quality of service first",
+			weights: Weights{QualityOfService: 0.9, SecurityMetrics: 0.1},
+			want:    []string{"exact", "close", "vulnerable", "attacked", "slow"},
+		},
+		{
+			name:    "security first",
+			weights: Weights{QualityOfService: 0.1, SecurityMetrics: 0.9},
+			want:    []string{"exact", "close", "slow", "failing", "vulnerable"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb := NewMemoryKnowledgeBase(policies)
+			matches, err := kb.Search(context.Background(), testMetrics, tt.weights)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if len(matches) != knowledgeSearchSize {
+				t.Fatalf("got %d matches, want %d", len(matches), knowledgeSearchSize)
+			}
+			for i, match := range matches {
+				if match.Policy.PolicyName != tt.want[i] {
+					t.Errorf("match %d = %s, want %s", i, match.Policy.PolicyName, tt.want[i])
+				}
+				if i > 0 && match.Score > matches[i-1].Score {
+					t.Errorf("match %d scores %v, more than the previous one %v", i, match.Score, matches[i-1].Score)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryKnowledgeBaseSearchErrors(t *testing.T) {
+	if _, err := NewMemoryKnowledgeBase(nil).Search(context.Background(), testMetrics, Weights{}); err == nil {
+		t.Error("searching an empty knowledge base succeeded")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	kb := NewMemoryKnowledgeBase([]Policy{testPolicy("exact", `{"response_time_ms": 100}`)})
+	if _, err := kb.Search(ctx, testMetrics, Weights{}); err == nil {
+		t.Error("searching with a cancelled context succeeded")
+	}
+}
+
+func TestMemoryKnowledgeBaseLearn(t *testing.T) {
+	kb := NewMemoryKnowledgeBase(nil)
+	policy := testPolicy("learned", `{"response_time_ms": 100, "error_rate": 0.01, "vulnerability_count": 5, "intrusion_attempts": 10}`)
+	policy.ID = "learned"
+	if err := kb.Learn(context.Background(), policy); err != nil {
+		t.Fatalf("Learn: %v", err)
+	}
+	// A policy with the same document ID replaces the learned one
+	policy.OutcomeScore = 0.5
+	if err := kb.Learn(context.Background(), policy); err != nil {
+		t.Fatalf("Learn: %v", err)
+	}
+
+	matches, err := kb.Search(context.Background(), testMetrics, Weights{})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("got %d matches, want 1", len(matches))
+	}
+	if matches[0].Policy.OutcomeScore != 0.5 {
+		t.Errorf("outcome score = %v, want the replaced policy's 0.5", matches[0].Policy.OutcomeScore)
+	}
+
+	if err := kb.Learn(context.Background(), Policy{PolicyName: "invalid"}); err == nil {
+		t.Error("learning an invalid policy succeeded")
+	}
+}
@@ -0,0 +1,139 @@
+package mtd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// LearnerSettings configures how movement outcomes are turned into learned policies
+type LearnerSettings struct {
+	// Window is how long the metrics are observed after a movement before its outcome is scored
+	Window time.Duration
+	// MinScore is the lowest outcome score a movement needs to be learned, above 0
+	MinScore float64
+}
+
+// Learner feeds the outcome of movements back into the knowledge base.
+// After a movement it waits for the observation window, reads the metrics again and,
+// if they improved enough, writes the metrics the movement was decided on
+// and the actions it applied as a learned policy, so similar situations retrieve it later.
+// Each variant has one learned policy, holding the last metrics it improved.
+type Learner struct {
+	knowledge KnowledgeWriter
+	observe   func(ctx context.Context) (Metrics, error)
+	settings  LearnerSettings
+}
+
+// NewLearner creates a Learner writing to knowledge and reading post-movement metrics with observe
+func NewLearner(knowledge KnowledgeWriter, observe func(ctx context.Context) (Metrics, error), settings LearnerSettings) (*Learner, error) {
+	if knowledge == nil || observe == nil {
+		return nil, fmt.Errorf("learner needs a knowledge base and a metrics source")
+	}
+	if settings.Window <= 0 {
+		return nil, fmt.Errorf("invalid observation window %s", settings.Window)
+	}
+	if settings.MinScore <= 0 || settings.MinScore > 1 {
+		return nil, fmt.Errorf("invalid minimum outcome score %f, must be above 0 and at most 1", settings.MinScore)
+	}
+	return &Learner{
+		knowledge: knowledge,
+		observe:   observe,
+		settings:  settings,
+	}, nil
+}
+
+// Observe waits for the observation window, scores the outcome of the movement applied when the metrics were before
+// and learns it if it improved them enough. rotatedIP tells whether the movement changed the IP.
+// It returns early with ctx's error when ctx is cancelled.
+func (l *Learner) Observe(ctx context.Context, before Metrics, decision MovementDecision, rotatedIP bool) error {
+	timer := time.NewTimer(l.settings.Window)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+
+	after, err := l.observe(ctx)
+	if err != nil {
+		return fmt.Errorf("observing metrics: %w", err)
+	}
+
+	score := OutcomeScore(before, after)
+	variant := VariantName(decision)
+	if score < l.settings.MinScore {
+		log.Printf("Movement to %s scored %.2f, not learned", variant, score)
+		return nil
+	}
+
+	policy := Policy{
+		PolicyName: fmt.Sprintf("Learned %s", variant),
+		Criteria:   Criteria(before.Values()),
+		RecommendedActions: RecommendedActions{
+			SwitchLanguage: decision.Language,
+			SwitchFormat:   decision.Format,
+			SwitchOS:       decision.OS,
+			RotateIP:       rotatedIP,
+		},
+		Source: PolicyLearned,
+	}
+	// One policy per variant and IP rotation: learning the same movement again updates it with the latest
+	// metrics, instead of adding a policy for every metric values the movement was decided on
+	policy.ID = "learned-" + variant
+	if rotatedIP {
+		policy.ID += "-rotated-ip"
+	}
+	policy.OutcomeScore = score
+	if err := l.knowledge.Learn(ctx, policy); err != nil {
+		return fmt.Errorf("learning policy: %w", err)
+	}
+	log.Printf("Movement to %s scored %.2f, learned as policy %s", variant, score, policy.DocumentID())
+	return nil
+}
+
+// OutcomeScore rates how much the metrics improved between before and after, from 0 (no improvement) to 1.
+// It averages the relative improvement of every metric of the catalog, in the direction its definition gives;
+// a metric that got worse counts as negative, one that is 0 before and after is left out, and the result is
+// clamped at 0.
+func OutcomeScore(before, after Metrics) float64 {
+	measured := before.Values()
+	for name, value := range after.Values() {
+		measured[name] = value
+	}
+
+	var total float64
+	var scored int
+	for _, name := range sortedMetricNames(measured) {
+		def, ok := LookupMetric(name)
+		if !ok {
+			continue
+		}
+		from, _ := before.Value(name)
+		to, _ := after.Value(name)
+		if from == 0 && to == 0 {
+			continue
+		}
+		if def.Direction == HigherIsBetter {
+			total += relativeDecrease(to, from)
+		} else {
+			total += relativeDecrease(from, to)
+		}
+		scored++
+	}
+	if scored == 0 {
+		return 0
+	}
+	return math.Max(total/float64(scored), 0)
+}
+
+// relativeDecrease returns how much value went down from before to after, relative to the larger of both, from -1 to 1
+func relativeDecrease(before, after float64) float64 {
+	largest := math.Max(before, after)
+	if largest <= 0 {
+		return 0
+	}
+	return (before - after) / largest
+}
@@ -0,0 +1,212 @@
+package mtd
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+// observing returns the metrics read after each movement, in turn
+func observing(metrics ...Metrics) func(ctx context.Context) (Metrics, error) {
+	var i int
+	return func(ctx context.Context) (Metrics, error) {
+		observed := metrics[i%len(metrics)]
+		i++
+		return observed, nil
+	}
+}
+
+func TestLearnerUpdatesOnePolicy(t *testing.T) {
+	kb := NewMemoryKnowledgeBase(nil)
+	improved := mustMetrics(`{
+		"quality_of_service": {"response_time_ms": 100, "error_rate": 0.01},
+		"security_metrics": {"vulnerability_count": 5, "intrusion_attempts": 1}
+	}`)
+	learner, err := NewLearner(kb, observing(improved), LearnerSettings{Window: time.Millisecond, MinScore: 0.1})
+	if err != nil {
+		t.Fatalf("NewLearner: %v", err)
+	}
+	ctx := context.Background()
+	decision := MovementDecision{Port: "8081", OS: "alpine", Language: "python", Format: "xml"}
+
+	// The same movement decided on metrics that differ only slightly
+	first := mustMetrics(`{
+		"quality_of_service": {"response_time_ms": 231.7, "error_rate": 0.0813},
+		"security_metrics": {"vulnerability_count": 5, "intrusion_attempts": 12}
+	}`)
+	second := mustMetrics(`{
+		"quality_of_service": {"response_time_ms": 232.4, "error_rate": 0.0821},
+		"security_metrics": {"vulnerability_count": 5, "intrusion_attempts": 13}
+	}`)
+	for _, before := range []Metrics{first, second} {
+		if err := learner.Observe(ctx, before, decision, false); err != nil {
+			t.Fatalf("Observe: %v", err)
+		}
+	}
+	if len(kb.policies) != 1 {
+		t.Fatalf("learning a movement twice left %d policies, want 1", len(kb.policies))
+	}
+	policy := kb.policies[0]
+	if policy.ID != "learned-alpine-python-xml" || policy.Source != PolicyLearned {
+		t.Errorf("learned policy %s from %s, want learned-alpine-python-xml from %s", policy.ID, policy.Source, PolicyLearned)
+	}
+	if policy.Criteria["response_time_ms"] != 232.4 {
+		t.Errorf("criteria = %v, want the metrics of the last movement", policy.Criteria)
+	}
+	want := OutcomeScore(second, improved)
+	if policy.OutcomeScore != want {
+		t.Errorf("outcome score = %v, want the last one %v", policy.OutcomeScore, want)
+	}
+
+	// Rotating the IP is another action, learned as its own policy
+	if err := learner.Observe(ctx, first, decision, true); err != nil {
+		t.Fatalf("Observe: %v", err)
+	}
+	if len(kb.policies) != 2 || kb.policies[1].ID != "learned-alpine-python-xml-rotated-ip" || !kb.policies[1].RecommendedActions.RotateIP {
+		t.Errorf("policies after rotating the IP = %+v, want a second learned-alpine-python-xml-rotated-ip policy", kb.policies)
+	}
+}
+
+func TestLearnerSkipsPoorOutcome(t *testing.T) {
+	kb := NewMemoryKnowledgeBase(nil)
+	before := mustMetrics(`{"quality_of_service": {"error_rate": 0.1}, "security_metrics": {"intrusion_attempts": 10}}`)
+	learner, err := NewLearner(kb, observing(before), LearnerSettings{Window: time.Millisecond, MinScore: 0.1})
+	if err != nil {
+		t.Fatalf("NewLearner: %v", err)
+	}
+	if err := learner.Observe(context.Background(), before, MovementDecision{OS: "ubuntu", Language: "go", Format: "json"}, false); err != nil {
+		t.Fatalf("Observe: %v", err)
+	}
+	if len(kb.policies) != 0 {
+		t.Errorf("a movement that did not improve the metrics was learned: %+v", kb.policies)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := learner.Observe(ctx, before, MovementDecision{}, false); err != context.Canceled {
+		t.Errorf("Observe with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestOutcomeScore(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          float64
+	}{
+		{
+			name:   "improved",
+			before: `{"quality_of_service": {"error_rate": 0.1}, "security_metrics": {"intrusion_attempts": 10}}`,
+			after:  `{"quality_of_service": {"error_rate": 0.05}, "security_metrics": {"intrusion_attempts": 0}}`,
+			want:   0.75,
+		},
+		{
+			name:   "worsened",
+			before: `{"quality_of_service": {"error_rate": 0.05}, "security_metrics": {"intrusion_attempts": 0}}`,
+			after:  `{"quality_of_service": {"error_rate": 0.1}, "security_metrics": {"intrusion_attempts": 10}}`,
+			want:   0,
+		},
+		{
+			name:   "mixed",
+			before: `{"quality_of_service": {"error_rate": 0.1}, "security_metrics": {"intrusion_attempts": 4}}`,
+			after:  `{"quality_of_service": {"error_rate": 0.02}, "security_metrics": {"intrusion_attempts": 5}}`,
+			want:   0.3,
+		},
+		{
+			name:   "every metric of the catalog",
+			before: `{"quality_of_service": {"response_time_ms": 400, "error_rate": 0.1}, "security_metrics": {"vulnerability_count": 4, "intrusion_attempts": 10}}`,
+			after:  `{"quality_of_service": {"response_time_ms": 100, "error_rate": 0.1}, "security_metrics": {"vulnerability_count": 4, "intrusion_attempts": 5}}`,
+			want:   (0.75 + 0 + 0 + 0.5) / 4,
+		},
+		{
+			name:   "both zero",
+			before: `{"quality_of_service": {"error_rate": 0}, "security_metrics": {"intrusion_attempts": 0}}`,
+			after:  `{"quality_of_service": {"error_rate": 0}, "security_metrics": {"intrusion_attempts": 0}}`,
+			want:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := OutcomeScore(mustMetrics(tt.before), mustMetrics(tt.after)); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("OutcomeScore = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutcomeScoreDeclaredMetrics(t *testing.T) {
+	if err := ReplaceMetrics([]MetricDefinition{
+		{Name: "auth_failures", Unit: "count", Category: CategorySecurity, Direction: LowerIsBetter, Scale: 5},
+		{Name: "uptime_ratio", Unit: "ratio", Category: CategoryAssetValue, Direction: HigherIsBetter, Scale: 0.1},
+	}); err != nil {
+		t.Fatalf("ReplaceMetrics: %v", err)
+	}
+	t.Cleanup(func() { ReplaceMetrics(nil) })
+
+	tests := []struct {
+		name          string
+		before, after string
+		want          float64
+	}{
+		{
+			name:   "lower is better",
+			before: `{"extra": {"auth_failures": 20}}`,
+			after:  `{"extra": {"auth_failures": 5}}`,
+			want:   0.75,
+		},
+		{
+			name:   "higher is better",
+			before: `{"extra": {"uptime_ratio": 0.5}}`,
+			after:  `{"extra": {"uptime_ratio": 1}}`,
+			want:   0.5,
+		},
+		{
+			name:   "with the built-in metrics",
+			before: `{"quality_of_service": {"error_rate": 0.1}, "extra": {"auth_failures": 20, "uptime_ratio": 1}}`,
+			after:  `{"quality_of_service": {"error_rate": 0.1}, "extra": {"auth_failures": 10, "uptime_ratio": 0.8}}`,
+			want:   (0 + 0.5 - 0.2) / 3,
+		},
+		{
+			name:   "only measured after",
+			before: `{}`,
+			after:  `{"extra": {"uptime_ratio": 0.9}}`,
+			want:   1,
+		},
+		{
+			name:   "undeclared metrics are ignored",
+			before: `{"extra": {"auth_failures": 20, "cpu_usage_percent": 90}}`,
+			after:  `{"extra": {"auth_failures": 10, "cpu_usage_percent": 10}}`,
+			want:   0.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := OutcomeScore(mustMetrics(tt.before), mustMetrics(tt.after)); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("OutcomeScore = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelativeDecrease(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after float64
+		want          float64
+	}{
+		{name: "halved", before: 10, after: 5, want: 0.5},
+		{name: "gone", before: 10, after: 0, want: 1},
+		{name: "doubled", before: 5, after: 10, want: -0.5},
+		{name: "appeared", before: 0, after: 3, want: -1},
+		{name: "unchanged", before: 4, after: 4, want: 0},
+		{name: "both zero", before: 0, after: 0, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := relativeDecrease(tt.before, tt.after); got != tt.want {
+				t.Errorf("relativeDecrease(%v, %v) = %v, want %v", tt.before, tt.after, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package mtd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Direction tells which way a metric improves
+type Direction string
+
+const (
+	LowerIsBetter  Direction = "lower_is_better"
+	HigherIsBetter Direction = "higher_is_better"
+)
+
+// Metric categories, each weighted by the Weights field of the same name
+const (
+	CategoryQualityOfService = "quality_of_service"
+	CategorySecurity         = "security_metrics"
+	CategoryAssetValue       = "asset_value"
+)
+
+// MetricDefinition describes a named metric the strategies compare: the current value with the
+// policy criteria and the thresholds of the same name
+type MetricDefinition struct {
+	Name        string    `json:"name"`
+	Unit        string    `json:"unit"`     // e.g. "ms", "count", or "ratio" for values between 0 and 1
+	Category    string    `json:"category"` // Weight the metric counts with
+	Direction   Direction `json:"direction"`
+	Scale       float64   `json:"scale"`                 // Distance at which a policy criterion is half as similar
+	Description string    `json:"description,omitempty"` // What the metric measures, given to the advisor
+}
+
+// The built-in metrics are fields of Metrics and Thresholds, the others are held by name in their Extra map
+var builtinMetrics = []MetricDefinition{
+	{Name: "response_time_ms", Unit: "ms", Category: CategoryQualityOfService, Direction: LowerIsBetter, Scale: 100, Description: "response time"},
+	{Name: "error_rate", Unit: "ratio", Category: CategoryQualityOfService, Direction: LowerIsBetter, Scale: 0.02, Description: "share of failed requests"},
+	{Name: "vulnerability_count", Unit: "count", Category: CategorySecurity, Direction: LowerIsBetter, Scale: 10, Description: "known vulnerabilities"},
+	{Name: "intrusion_attempts", Unit: "count", Category: CategorySecurity, Direction: LowerIsBetter, Scale: 20, Description: "intrusion attempts detected"},
+}
+
+var (
+	catalogMu sync.RWMutex
+	catalog   = append([]MetricDefinition(nil), builtinMetrics...)
+)
+
+// IsBuiltinMetric reports whether name is one of the metrics Metrics and Thresholds have a field for
+func IsBuiltinMetric(name string) bool {
+	for _, def := range builtinMetrics {
+		if def.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterMetric makes a metric available to the strategies and the knowledge bases, replacing any previous
+// definition with the same name. Built-in metrics cannot be replaced.
+func RegisterMetric(def MetricDefinition) error {
+	if err := def.Validate(); err != nil {
+		return fmt.Errorf("metric %q: %w", def.Name, err)
+	}
+	if IsBuiltinMetric(def.Name) {
+		return fmt.Errorf("metric %q is built in", def.Name)
+	}
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	for i, registered := range catalog {
+		if registered.Name == def.Name {
+			catalog[i] = def
+			return nil
+		}
+	}
+	catalog = append(catalog, def)
+	return nil
+}
+
+// ReplaceMetrics makes definitions the only metrics available besides the built-in ones, unregistering the
+// others. Nothing is changed when a definition is invalid. Later definitions replace earlier ones of the same name.
+func ReplaceMetrics(definitions []MetricDefinition) error {
+	replaced := append([]MetricDefinition(nil), builtinMetrics...)
+	positions := make(map[string]int)
+	var errs []error
+	for _, def := range definitions {
+		if err := def.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("metric %q: %w", def.Name, err))
+			continue
+		}
+		if IsBuiltinMetric(def.Name) {
+			errs = append(errs, fmt.Errorf("metric %q is built in", def.Name))
+			continue
+		}
+		if i, ok := positions[def.Name]; ok {
+			replaced[i] = def
+			continue
+		}
+		positions[def.Name] = len(replaced)
+		replaced = append(replaced, def)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	catalog = replaced
+	return nil
+}
+
+// MetricDefinitions returns every registered metric, the built-in ones first
+func MetricDefinitions() []MetricDefinition {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	return append([]MetricDefinition(nil), catalog...)
+}
+
+// LookupMetric returns the definition of the named metric
+func LookupMetric(name string) (MetricDefinition, bool) {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	for _, def := range catalog {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return MetricDefinition{}, false
+}
+
+// Validate reports every problem of a metric definition
+func (d MetricDefinition) Validate() error {
+	var problems []string
+	if strings.TrimSpace(d.Name) == "" {
+		problems = append(problems, "name is empty")
+	}
+	switch d.Category {
+	case CategoryQualityOfService, CategorySecurity, CategoryAssetValue:
+	default:
+		problems = append(problems, fmt.Sprintf("category %q is not %s, %s or %s", d.Category, CategoryQualityOfService, CategorySecurity, CategoryAssetValue))
+	}
+	if d.Direction != LowerIsBetter && d.Direction != HigherIsBetter {
+		problems = append(problems, fmt.Sprintf("direction %q is not %s or %s", d.Direction, LowerIsBetter, HigherIsBetter))
+	}
+	if d.Scale <= 0 {
+		problems = append(problems, "scale is not positive")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// headroom returns how far value is on the good side of threshold, 0 when it is past it
+func (d MetricDefinition) headroom(value, threshold float64) float64 {
+	if d.Direction == HigherIsBetter {
+		return math.Max(0, value-threshold)
+	}
+	return math.Max(0, threshold-value)
+}
+
+// Value returns the named metric, built-in or extra
+func (m Metrics) Value(name string) (float64, bool) {
+	switch name {
+	case "response_time_ms":
+		return m.QualityOfService.ResponseTimeMs, true
+	case "error_rate":
+		return m.QualityOfService.ErrorRate, true
+	case "vulnerability_count":
+		return float64(m.SecurityMetrics.VulnerabilityCount), true
+	case "intrusion_attempts":
+		return float64(m.SecurityMetrics.IntrusionAttempts), true
+	}
+	value, ok := m.Extra[name]
+	return value, ok
+}
+
+// Set sets the named metric, built-in or extra. Counts are rounded.
+func (m *Metrics) Set(name string, value float64) {
+	switch name {
+	case "response_time_ms":
+		m.QualityOfService.ResponseTimeMs = value
+	case "error_rate":
+		m.QualityOfService.ErrorRate = value
+	case "vulnerability_count":
+		m.SecurityMetrics.VulnerabilityCount = int(math.Round(value))
+	case "intrusion_attempts":
+		m.SecurityMetrics.IntrusionAttempts = int(math.Round(value))
+	default:
+		if m.Extra == nil {
+			m.Extra = make(map[string]float64)
+		}
+		m.Extra[name] = value
+	}
+}
+
+// Values returns every metric that has a value, by name
+func (m Metrics) Values() map[string]float64 {
+	values := make(map[string]float64, len(builtinMetrics)+len(m.Extra))
+	for _, def := range builtinMetrics {
+		values[def.Name], _ = m.Value(def.Name)
+	}
+	for name, value := range m.Extra {
+		values[name] = value
+	}
+	return values
+}
+
+// Value returns the threshold of the named metric, built-in or extra
+func (t Thresholds) Value(name string) (float64, bool) {
+	switch name {
+	case "response_time_ms":
+		return t.ResponseTimeMs, true
+	case "error_rate":
+		return t.ErrorRate, true
+	case "vulnerability_count":
+		return float64(t.VulnerabilityCount), true
+	case "intrusion_attempts":
+		return float64(t.IntrusionAttempts), true
+	}
+	value, ok := t.Extra[name]
+	return value, ok
+}
+
+// Of returns the weight of a metric category
+func (w Weights) Of(category string) float64 {
+	switch category {
+	case CategoryQualityOfService:
+		return w.QualityOfService
+	case CategorySecurity:
+		return w.SecurityMetrics
+	case CategoryAssetValue:
+		return w.AssetValue
+	}
+	return 0
+}
+
+// categoryScore sums how far the metrics of a category are on the good side of their thresholds
+func categoryScore(metrics Metrics, thresholds Thresholds, category string) float64 {
+	var score float64
+	for _, def := range MetricDefinitions() {
+		if def.Category != category {
+			continue
+		}
+		value, ok := metrics.Value(def.Name)
+		threshold, hasThreshold := thresholds.Value(def.Name)
+		if ok && hasThreshold {
+			score += def.headroom(value, threshold)
+		}
+	}
+	return score
+}
+
+// sortedMetricNames returns the names of values in catalog order, then the unknown ones alphabetically
+func sortedMetricNames(values map[string]float64) []string {
+	names := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, def := range MetricDefinitions() {
+		if _, ok := values[def.Name]; ok {
+			names = append(names, def.Name)
+			seen[def.Name] = true
+		}
+	}
+	var unknown []string
+	for name := range values {
+		if !seen[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return append(names, unknown...)
+}
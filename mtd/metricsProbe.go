@@ -0,0 +1,174 @@
+package mtd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// ProbeResult is a request the client made to the service, as written to its client_log.json
+type ProbeResult struct {
+	Timestamp      time.Time `json:"timestamp"`
+	ResponseTimeMs float64   `json:"response_time_ms"`
+	StatusCode     int       `json:"status_code"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// Failed reports whether the request got no answer or a server error
+func (r ProbeResult) Failed() bool {
+	return r.Error != "" || r.StatusCode >= 500
+}
+
+// ProbeStats summarises the probe results of a window
+type ProbeStats struct {
+	Count     int
+	Failures  int
+	ErrorRate float64
+	P50Ms     float64
+	P95Ms     float64
+	P99Ms     float64
+}
+
+// ProbeSettings configures how probe results are aggregated
+type ProbeSettings struct {
+	// Window is how far back probe results are taken into account
+	Window time.Duration
+	// Percentile of the response times reported as the response time, 95 by default
+	Percentile float64
+}
+
+// ProbeCollector measures the quality of service from the client's probe results over a sliding window.
+// The security metrics and asset value, which probes cannot measure, come from a base source.
+type ProbeCollector struct {
+	logFile  string
+	base     MetricsSource
+	settings ProbeSettings
+	now      func() time.Time
+}
+
+// NewProbeCollector creates a collector reading the client log at logFile on top of the base metrics
+func NewProbeCollector(logFile string, base MetricsSource, settings ProbeSettings) (*ProbeCollector, error) {
+	if base == nil {
+		return nil, fmt.Errorf("probe collector needs a base metrics source")
+	}
+	if settings.Window <= 0 {
+		return nil, fmt.Errorf("invalid probe window %s", settings.Window)
+	}
+	if settings.Percentile == 0 {
+		settings.Percentile = 95
+	}
+	if settings.Percentile < 0 || settings.Percentile > 100 {
+		return nil, fmt.Errorf("invalid percentile %f, must be between 0 and 100", settings.Percentile)
+	}
+	return &ProbeCollector{
+		logFile:  logFile,
+		base:     base,
+		settings: settings,
+		now:      time.Now,
+	}, nil
+}
+
+// Collect returns the base metrics with the response time and error rate measured over the window.
+// Without probe results in the window, the base quality of service is kept.
+func (c *ProbeCollector) Collect(ctx context.Context) (Metrics, error) {
+	metrics, err := c.base.Collect(ctx)
+	if err != nil {
+		return metrics, err
+	}
+
+	results, err := c.window()
+	if err != nil {
+		return metrics, err
+	}
+	if len(results) == 0 {
+		log.Printf("No probe results in the last %s, keeping the configured quality of service", c.settings.Window)
+		return metrics, nil
+	}
+
+	stats := AggregateProbes(results)
+	log.Printf("Probe results in the last %s: %d requests, %d failed, p50 %.1f ms, p95 %.1f ms, p99 %.1f ms",
+		c.settings.Window, stats.Count, stats.Failures, stats.P50Ms, stats.P95Ms, stats.P99Ms)
+
+	metrics.QualityOfService.ResponseTimeMs = percentile(responseTimes(results), c.settings.Percentile)
+	metrics.QualityOfService.ErrorRate = stats.ErrorRate
+	return metrics, nil
+}
+
+// window reads the client log and keeps the results of the window
+func (c *ProbeCollector) window() ([]ProbeResult, error) {
+	data, err := os.ReadFile(c.logFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading probe results: %w", err)
+	}
+	var logData struct {
+		Entries []ProbeResult `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &logData); err != nil {
+		return nil, fmt.Errorf("decoding probe results %s: %w", c.logFile, err)
+	}
+
+	since := c.now().Add(-c.settings.Window)
+	var results []ProbeResult
+	for _, entry := range logData.Entries {
+		if entry.Timestamp.After(since) {
+			results = append(results, entry)
+		}
+	}
+	return results, nil
+}
+
+// AggregateProbes computes the error rate and response time percentiles of the probe results.
+// Failed requests count in the error rate but not in the response times, unless every request failed.
+func AggregateProbes(results []ProbeResult) ProbeStats {
+	stats := ProbeStats{Count: len(results)}
+	if stats.Count == 0 {
+		return stats
+	}
+	for _, result := range results {
+		if result.Failed() {
+			stats.Failures++
+		}
+	}
+	stats.ErrorRate = float64(stats.Failures) / float64(stats.Count)
+
+	times := responseTimes(results)
+	stats.P50Ms = percentile(times, 50)
+	stats.P95Ms = percentile(times, 95)
+	stats.P99Ms = percentile(times, 99)
+	return stats
+}
+
+// responseTimes returns the sorted response times of the successful requests,
+// or of all requests when every one of them failed
+func responseTimes(results []ProbeResult) []float64 {
+	var times []float64
+	for _, result := range results {
+		if !result.Failed() {
+			times = append(times, result.ResponseTimeMs)
+		}
+	}
+	if len(times) == 0 {
+		for _, result := range results {
+			times = append(times, result.ResponseTimeMs)
+		}
+	}
+	sort.Float64s(times)
+	return times
+}
+
+// percentile returns the nearest-rank percentile p of the sorted values, 0 without values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
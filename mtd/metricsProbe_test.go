@@ -0,0 +1,207 @@
+package mtd
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// probeNow is the time the probe collector tests collect at
+var probeNow = time.Date(2024, 10, 6, 12, 0, 0, 0, time.UTC)
+
+// probes returns n successful probe results ago before probeNow, taking 1 to n ms
+func probes(n int, ago time.Duration) []ProbeResult {
+	results := make([]ProbeResult, n)
+	for i := range results {
+		results[i] = ProbeResult{Timestamp: probeNow.Add(-ago), ResponseTimeMs: float64(i + 1), StatusCode: 200}
+	}
+	return results
+}
+
+// newTestProbeCollector writes the probe results as a client log and the base metrics to a temporary directory,
+// and returns a collector reading them at probeNow
+func newTestProbeCollector(t *testing.T, results []ProbeResult, settings ProbeSettings) *ProbeCollector {
+	t.Helper()
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "client_log.json")
+	data, err := json.Marshal(map[string]interface{}{"entries": results})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(logFile, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(dir, "metrics.json")
+	data = []byte(`{"quality_of_service": {"response_time_ms": 500, "error_rate": 0.5}, "security_metrics": {"vulnerability_count": 7}}`)
+	if err := os.WriteFile(base, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	collector, err := NewProbeCollector(logFile, FileMetricsSource(base), settings)
+	if err != nil {
+		t.Fatalf("NewProbeCollector: %v", err)
+	}
+	collector.now = func() time.Time { return probeNow }
+	return collector
+}
+
+func TestPercentile(t *testing.T) {
+	twenty := make([]float64, 20)
+	for i := range twenty {
+		twenty[i] = float64(i + 1)
+	}
+	tests := []struct {
+		name   string
+		sorted []float64
+		p      float64
+		want   float64
+	}{
+		{name: "empty", sorted: nil, p: 95, want: 0},
+		{name: "single", sorted: []float64{42}, p: 95, want: 42},
+		{name: "p95", sorted: twenty, p: 95, want: 19},
+		{name: "p99", sorted: twenty, p: 99, want: 20},
+		{name: "median of even count", sorted: []float64{1, 2, 3, 4}, p: 50, want: 2},
+		{name: "p100", sorted: twenty, p: 100, want: 20},
+		{name: "p0", sorted: twenty, p: 0, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateProbes(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []ProbeResult
+		want    ProbeStats
+	}{
+		{
+			name: "empty",
+			want: ProbeStats{},
+		},
+		{
+			name:    "all successful",
+			results: probes(20, time.Minute),
+			want:    ProbeStats{Count: 20, P50Ms: 10, P95Ms: 19, P99Ms: 20},
+		},
+		{
+			name: "server errors and no answers",
+			results: append(probes(8, time.Minute),
+				ProbeResult{ResponseTimeMs: 900, StatusCode: 503},
+				ProbeResult{ResponseTimeMs: 3000, Error: "connection refused"},
+				ProbeResult{ResponseTimeMs: 5, StatusCode: 404}, // Client errors are answers
+			),
+			want: ProbeStats{Count: 11, Failures: 2, ErrorRate: 2.0 / 11, P50Ms: 5, P95Ms: 8, P99Ms: 8},
+		},
+		{
+			name: "all failed",
+			results: []ProbeResult{
+				{ResponseTimeMs: 30, StatusCode: 500},
+				{ResponseTimeMs: 10, Error: "timeout"},
+			},
+			want: ProbeStats{Count: 2, Failures: 2, ErrorRate: 1, P50Ms: 10, P95Ms: 30, P99Ms: 30},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AggregateProbes(tt.results); got != tt.want {
+				t.Errorf("AggregateProbes = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeCollectorCollect(t *testing.T) {
+	window := 5 * time.Minute
+	failed := []ProbeResult{
+		{Timestamp: probeNow.Add(-time.Minute), ResponseTimeMs: 900, StatusCode: 502},
+		{Timestamp: probeNow.Add(-time.Minute), ResponseTimeMs: 3000, Error: "connection refused"},
+	}
+	tests := []struct {
+		name         string
+		results      []ProbeResult
+		percentile   float64
+		responseTime float64
+		errorRate    float64
+	}{
+		{
+			name:         "p95 of the window",
+			results:      probes(20, time.Minute),
+			responseTime: 19,
+			errorRate:    0,
+		},
+		{
+			name:         "configured percentile",
+			results:      probes(20, time.Minute),
+			percentile:   50,
+			responseTime: 10,
+			errorRate:    0,
+		},
+		{
+			name:         "failures",
+			results:      append(probes(18, time.Minute), failed...),
+			responseTime: 18,
+			errorRate:    0.1,
+		},
+		{
+			name: "results out of the window are ignored",
+			results: append(append(probes(10, time.Minute), failed...),
+				ProbeResult{Timestamp: probeNow.Add(-window), ResponseTimeMs: 1000, StatusCode: 500},
+				ProbeResult{Timestamp: probeNow.Add(-time.Hour), ResponseTimeMs: 2000, StatusCode: 500},
+			),
+			responseTime: 10,
+			errorRate:    2.0 / 12,
+		},
+		{
+			name:         "empty window keeps the configured quality of service",
+			results:      probes(20, time.Hour),
+			responseTime: 500,
+			errorRate:    0.5,
+		},
+		{
+			name:         "no results",
+			responseTime: 500,
+			errorRate:    0.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := newTestProbeCollector(t, tt.results, ProbeSettings{Window: window, Percentile: tt.percentile})
+			metrics, err := collector.Collect(context.Background())
+			if err != nil {
+				t.Fatalf("Collect: %v", err)
+			}
+			if got := metrics.QualityOfService.ResponseTimeMs; got != tt.responseTime {
+				t.Errorf("response time = %v, want %v", got, tt.responseTime)
+			}
+			if got := metrics.QualityOfService.ErrorRate; math.Abs(got-tt.errorRate) > 1e-9 {
+				t.Errorf("error rate = %v, want %v", got, tt.errorRate)
+			}
+			if metrics.SecurityMetrics.VulnerabilityCount != 7 {
+				t.Errorf("vulnerability count = %d, want the base 7", metrics.SecurityMetrics.VulnerabilityCount)
+			}
+		})
+	}
+}
+
+func TestProbeCollectorErrors(t *testing.T) {
+	collector := newTestProbeCollector(t, nil, ProbeSettings{Window: time.Minute})
+	collector.logFile = filepath.Join(t.TempDir(), "missing.json")
+	if _, err := collector.Collect(context.Background()); err == nil {
+		t.Error("collecting without a client log succeeded")
+	}
+
+	for _, settings := range []ProbeSettings{{}, {Window: time.Minute, Percentile: 101}, {Window: time.Minute, Percentile: -1}} {
+		if _, err := NewProbeCollector("client_log.json", FileMetricsSource(""), settings); err == nil {
+			t.Errorf("NewProbeCollector accepted %+v", settings)
+		}
+	}
+}
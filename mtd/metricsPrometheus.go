@@ -0,0 +1,197 @@
+package mtd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PrometheusQueries maps the fields of Metrics to the PromQL expressions measuring them.
+// Each expression must return a single value, fields without an expression keep their base value.
+type PrometheusQueries struct {
+	ResponseTimeMs     string `json:"response_time_ms"`
+	ErrorRate          string `json:"error_rate"`
+	VulnerabilityCount string `json:"vulnerability_count"`
+	IntrusionAttempts  string `json:"intrusion_attempts"`
+	CriticalAssets     string `json:"critical_assets"`
+	HighValueAssets    string `json:"high_value_assets"`
+	// Extra maps registered metrics beyond the built-in ones to their expression
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// LoadPrometheusQueries reads the PromQL expressions from a JSON file
+func LoadPrometheusQueries(filepath string) (PrometheusQueries, error) {
+	var queries PrometheusQueries
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return queries, err
+	}
+	err = json.Unmarshal(data, &queries)
+	return queries, err
+}
+
+// PrometheusSource measures the metrics with instant queries to a Prometheus compatible HTTP API.
+// The fields without a query come from a base source.
+type PrometheusSource struct {
+	endpoint *url.URL
+	client   *http.Client
+	queries  PrometheusQueries
+	base     MetricsSource
+}
+
+// NewPrometheusSource creates a source querying the Prometheus API at baseURL, e.g. http://localhost:9090,
+// with client, or http.DefaultClient when client is nil
+func NewPrometheusSource(baseURL string, client *http.Client, queries PrometheusQueries, base MetricsSource) (*PrometheusSource, error) {
+	if base == nil {
+		return nil, fmt.Errorf("prometheus source needs a base metrics source")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/api/v1/query")
+	if err != nil {
+		return nil, fmt.Errorf("invalid prometheus URL %q: %w", baseURL, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid prometheus URL %q: scheme must be http or https", baseURL)
+	}
+	for name := range queries.Extra {
+		if _, ok := LookupMetric(name); !ok || IsBuiltinMetric(name) {
+			return nil, fmt.Errorf("prometheus query for %q: not a registered extra metric", name)
+		}
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &PrometheusSource{
+		endpoint: endpoint,
+		client:   client,
+		queries:  queries,
+		base:     base,
+	}, nil
+}
+
+// Collect returns the base metrics with every field that has a query replaced by its result.
+// It fails if any query fails, listing all of them.
+func (s *PrometheusSource) Collect(ctx context.Context) (Metrics, error) {
+	metrics, err := s.base.Collect(ctx)
+	if err != nil {
+		return metrics, err
+	}
+
+	var errs []error
+	set := func(field, query string, apply func(float64)) {
+		if query == "" {
+			return
+		}
+		value, err := s.Query(ctx, query)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+			return
+		}
+		apply(value)
+	}
+	set("response_time_ms", s.queries.ResponseTimeMs, func(v float64) { metrics.QualityOfService.ResponseTimeMs = v })
+	set("error_rate", s.queries.ErrorRate, func(v float64) { metrics.QualityOfService.ErrorRate = v })
+	set("vulnerability_count", s.queries.VulnerabilityCount, func(v float64) { metrics.SecurityMetrics.VulnerabilityCount = int(math.Round(v)) })
+	set("intrusion_attempts", s.queries.IntrusionAttempts, func(v float64) { metrics.SecurityMetrics.IntrusionAttempts = int(math.Round(v)) })
+	set("critical_assets", s.queries.CriticalAssets, func(v float64) { metrics.AssetValue.CriticalAssets = int(math.Round(v)) })
+	set("high_value_assets", s.queries.HighValueAssets, func(v float64) { metrics.AssetValue.HighValueAssets = int(math.Round(v)) })
+	names := make([]string, 0, len(s.queries.Extra))
+	for name := range s.queries.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		set(name, s.queries.Extra[name], func(v float64) { metrics.Set(name, v) })
+	}
+
+	return metrics, errors.Join(errs...)
+}
+
+// prometheusResponse is the body of an instant query answer
+type prometheusResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// Query runs an instant query and returns its single value. Vector results must have exactly one sample.
+func (s *PrometheusSource) Query(ctx context.Context, query string) (float64, error) {
+	endpoint := *s.endpoint
+	endpoint.RawQuery = url.Values{"query": {query}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	var answer prometheusResponse
+	if err := json.Unmarshal(body, &answer); err != nil {
+		return 0, fmt.Errorf("unexpected answer (status %d): %w", res.StatusCode, err)
+	}
+	if answer.Status != "success" {
+		return 0, fmt.Errorf("query %q failed: %s: %s", query, answer.ErrorType, answer.Error)
+	}
+
+	var sample []interface{}
+	switch answer.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(answer.Data.Result, &sample); err != nil {
+			return 0, fmt.Errorf("decoding scalar: %w", err)
+		}
+	case "vector":
+		var vector []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(answer.Data.Result, &vector); err != nil {
+			return 0, fmt.Errorf("decoding vector: %w", err)
+		}
+		if len(vector) == 0 {
+			return 0, fmt.Errorf("query %q returned no sample", query)
+		}
+		if len(vector) > 1 {
+			return 0, fmt.Errorf("query %q returned %d samples, aggregate it to a single one", query, len(vector))
+		}
+		sample = vector[0].Value
+	default:
+		return 0, fmt.Errorf("query %q returned a %s, expected a scalar or a vector", query, answer.Data.ResultType)
+	}
+
+	// Samples are [timestamp, "value"]
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("query %q returned a malformed sample", query)
+	}
+	text, ok := sample[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("query %q returned a malformed sample value", query)
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("query %q returned %q: %w", query, text, err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("query %q returned %s", query, text)
+	}
+	return value, nil
+}
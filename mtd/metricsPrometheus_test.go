@@ -0,0 +1,172 @@
+package mtd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakePrometheus serves the answers to instant queries, by query
+func fakePrometheus(t *testing.T, answers map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+		answer, ok := answers[r.URL.Query().Get("query")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"status": "error", "errorType": "bad_data", "error": "unknown query"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, answer)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPrometheusSourceQuery(t *testing.T) {
+	answers := map[string]string{
+		"vector":    `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1700000000.5, "123.4"]}]}}`,
+		"scalar":    `{"status": "success", "data": {"resultType": "scalar", "result": [1700000000.5, "0.02"]}}`,
+		"empty":     `{"status": "success", "data": {"resultType": "vector", "result": []}}`,
+		"several":   `{"status": "success", "data": {"resultType": "vector", "result": [{"value": [1, "1"]}, {"value": [1, "2"]}]}}`,
+		"failed":    `{"status": "error", "errorType": "execution", "error": "query timed out"}`,
+		"text":      `{"status": "success", "data": {"resultType": "vector", "result": [{"value": [1700000000.5, "fast"]}]}}`,
+		"nan":       `{"status": "success", "data": {"resultType": "vector", "result": [{"value": [1700000000.5, "NaN"]}]}}`,
+		"number":    `{"status": "success", "data": {"resultType": "vector", "result": [{"value": [1700000000.5, 12]}]}}`,
+		"matrix":    `{"status": "success", "data": {"resultType": "matrix", "result": []}}`,
+		"malformed": `<html>Bad Gateway</html>`,
+	}
+	server := fakePrometheus(t, answers)
+	source, err := NewPrometheusSource(server.URL+"/", server.Client(), PrometheusQueries{}, FileMetricsSource(""))
+	if err != nil {
+		t.Fatalf("NewPrometheusSource: %v", err)
+	}
+
+	tests := []struct {
+		query   string
+		want    float64
+		wantErr string
+	}{
+		{query: "vector", want: 123.4},
+		{query: "scalar", want: 0.02},
+		{query: "empty", wantErr: "no sample"},
+		{query: "several", wantErr: "2 samples"},
+		{query: "failed", wantErr: "execution: query timed out"},
+		{query: "unknown", wantErr: "bad_data: unknown query"},
+		{query: "text", wantErr: `returned "fast"`},
+		{query: "nan", wantErr: "returned NaN"},
+		{query: "number", wantErr: "malformed sample value"},
+		{query: "matrix", wantErr: "returned a matrix"},
+		{query: "malformed", wantErr: "unexpected answer (status 200)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := source.Query(context.Background(), tt.query)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Query error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Query = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrometheusSourceCollect(t *testing.T) {
+	if err := ReplaceMetrics([]MetricDefinition{{Name: "cpu_usage_percent", Unit: "percent", Category: CategoryQualityOfService, Direction: LowerIsBetter, Scale: 10}}); err != nil {
+		t.Fatalf("ReplaceMetrics: %v", err)
+	}
+	t.Cleanup(func() { ReplaceMetrics(nil) })
+
+	base := filepath.Join(t.TempDir(), "metrics.json")
+	data := `{"quality_of_service": {"response_time_ms": 500, "error_rate": 0.5}, "security_metrics": {"vulnerability_count": 7}, "asset_value": {"critical_assets": 3}}`
+	if err := os.WriteFile(base, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := fakePrometheus(t, map[string]string{
+		"latency": `{"status": "success", "data": {"resultType": "scalar", "result": [1, "120"]}}`,
+		"attacks": `{"status": "success", "data": {"resultType": "vector", "result": [{"value": [1, "3.6"]}]}}`,
+		"cpu":     `{"status": "success", "data": {"resultType": "vector", "result": [{"value": [1, "42"]}]}}`,
+		"errors":  `{"status": "success", "data": {"resultType": "vector", "result": []}}`,
+	})
+	queries := PrometheusQueries{
+		ResponseTimeMs:    "latency",
+		IntrusionAttempts: "attacks",
+		Extra:             map[string]string{"cpu_usage_percent": "cpu"},
+	}
+	source, err := NewPrometheusSource(server.URL, server.Client(), queries, FileMetricsSource(base))
+	if err != nil {
+		t.Fatalf("NewPrometheusSource: %v", err)
+	}
+
+	metrics, err := source.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	want := map[string]float64{
+		"response_time_ms":    120,
+		"error_rate":          0.5, // No query, from the base source
+		"vulnerability_count": 7,
+		"intrusion_attempts":  4, // Counts are rounded
+		"cpu_usage_percent":   42,
+	}
+	for name, value := range want {
+		if got, _ := metrics.Value(name); got != value {
+			t.Errorf("%s = %v, want %v", name, got, value)
+		}
+	}
+	if metrics.AssetValue.CriticalAssets != 3 {
+		t.Errorf("critical assets = %d, want the base 3", metrics.AssetValue.CriticalAssets)
+	}
+
+	// Every failing query is reported
+	queries.ErrorRate = "errors"
+	queries.VulnerabilityCount = "unknown"
+	source, err = NewPrometheusSource(server.URL, server.Client(), queries, FileMetricsSource(base))
+	if err != nil {
+		t.Fatalf("NewPrometheusSource: %v", err)
+	}
+	_, err = source.Collect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "error_rate") || !strings.Contains(err.Error(), "vulnerability_count") {
+		t.Errorf("Collect error = %v, want both failing queries", err)
+	}
+}
+
+func TestNewPrometheusSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		queries PrometheusQueries
+		base    MetricsSource
+	}{
+		{name: "no base source", url: "http://localhost:9090"},
+		{name: "not http", url: "ftp://localhost:9090", base: FileMetricsSource("")},
+		{name: "invalid URL", url: "http://local host:9090", base: FileMetricsSource("")},
+		{name: "unregistered extra metric", url: "http://localhost:9090", base: FileMetricsSource(""), queries: PrometheusQueries{Extra: map[string]string{"unknown_metric": "up"}}},
+		{name: "built-in metric as extra", url: "http://localhost:9090", base: FileMetricsSource(""), queries: PrometheusQueries{Extra: map[string]string{"error_rate": "up"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPrometheusSource(tt.url, nil, tt.queries, tt.base); err == nil {
+				t.Error("NewPrometheusSource succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package mtd
+
+import "context"
+
+// MetricsSource provides the current metrics a movement is decided on
+type MetricsSource interface {
+	Collect(ctx context.Context) (Metrics, error)
+}
+
+// Ensure the metrics sources satisfy the MetricsSource interface
+var (
+	_ MetricsSource = FileMetricsSource("")
+	_ MetricsSource = (*ProbeCollector)(nil)
+	_ MetricsSource = (*PrometheusSource)(nil)
+)
+
+// FileMetricsSource reads the metrics from a metrics.json file every time they are collected
+type FileMetricsSource string
+
+// Collect loads the metrics file
+func (path FileMetricsSource) Collect(ctx context.Context) (Metrics, error) {
+	if err := ctx.Err(); err != nil {
+		return Metrics{}, err
+	}
+	return LoadMetrics(string(path))
+}
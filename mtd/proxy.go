@@ -0,0 +1,202 @@
+package mtd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LivePath is answered by the proxy itself with the variant currently receiving the traffic
+const LivePath = "/_mtd/live"
+
+// TrafficSwitch sends the incoming traffic to a new backend
+type TrafficSwitch interface {
+	// Switch atomically sends new requests to target, the backend running decision.
+	// The returned channel is closed once the requests still running on the previous backend are done.
+	Switch(target string, decision MovementDecision) (<-chan struct{}, error)
+}
+
+// LiveVariant describes the backend currently receiving the traffic
+type LiveVariant struct {
+	Variant  string    `json:"variant"`
+	Backend  string    `json:"backend"`
+	IP       string    `json:"ip"`
+	Port     string    `json:"port"`
+	OS       string    `json:"os"`
+	Language string    `json:"language"`
+	Format   string    `json:"format"`
+	Since    time.Time `json:"since"`
+}
+
+// Proxy is the stable entry point of the service: it holds the public listener and forwards
+// every request to the backend activated by the last movement, whatever port it runs on
+type Proxy struct {
+	mu      sync.Mutex // serializes Switch
+	backend atomic.Pointer[proxyBackend]
+}
+
+// proxyBackend is a backend along with the requests it is serving
+type proxyBackend struct {
+	live  LiveVariant
+	proxy *httputil.ReverseProxy
+
+	mu       sync.Mutex
+	inFlight int
+	retired  bool
+	drained  chan struct{}
+}
+
+// NewProxy creates a Proxy without backend, answering 503 until Switch is called
+func NewProxy() *Proxy {
+	return &Proxy{}
+}
+
+// Switch atomically replaces the backend. New requests go to target right away,
+// while the requests already in flight finish on the previous backend.
+func (p *Proxy) Switch(target string, decision MovementDecision) (<-chan struct{}, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend %q: %w", target, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid backend %q: scheme and host are required", target)
+	}
+
+	live := LiveVariant{
+		Variant:  VariantName(decision),
+		Backend:  u.String(),
+		IP:       decision.IP,
+		Port:     decision.Port,
+		OS:       decision.OS,
+		Language: decision.Language,
+		Format:   decision.Format,
+		Since:    time.Now(),
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.ModifyResponse = func(res *http.Response) error {
+		res.Header.Set("X-MTD-Variant", live.Variant)
+		return nil
+	}
+	next := &proxyBackend{
+		live:    live,
+		proxy:   proxy,
+		drained: make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous := p.backend.Swap(next)
+	log.Printf("Proxy switched to %s (%s)", live.Backend, live.Variant)
+
+	if previous == nil {
+		drained := make(chan struct{})
+		close(drained)
+		return drained, nil
+	}
+	return previous.retire(), nil
+}
+
+// Live returns the variant currently receiving the traffic, if any
+func (p *Proxy) Live() (LiveVariant, bool) {
+	backend := p.backend.Load()
+	if backend == nil {
+		return LiveVariant{}, false
+	}
+	return backend.live, true
+}
+
+// ServeHTTP forwards the request to the active backend
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == LivePath {
+		p.serveLive(w)
+		return
+	}
+
+	for {
+		backend := p.backend.Load()
+		if backend == nil {
+			http.Error(w, "no backend available", http.StatusServiceUnavailable)
+			return
+		}
+		// A backend retired in the meantime no longer accepts requests, retry on the new one
+		if !backend.acquire() {
+			continue
+		}
+		defer backend.release()
+		backend.proxy.ServeHTTP(w, r)
+		return
+	}
+}
+
+// ListenAndServe serves the proxy on addr until ctx is cancelled, then waits
+// for the requests in flight to finish
+func (p *Proxy) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: p}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Proxy listening on %s", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (p *Proxy) serveLive(w http.ResponseWriter) {
+	live, ok := p.Live()
+	if !ok {
+		http.Error(w, "no backend available", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(live)
+}
+
+// acquire registers a request, unless the backend was retired
+func (b *proxyBackend) acquire() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.retired {
+		return false
+	}
+	b.inFlight++
+	return true
+}
+
+func (b *proxyBackend) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inFlight--
+	if b.retired && b.inFlight == 0 {
+		close(b.drained)
+	}
+}
+
+// retire stops new requests and returns a channel closed once the last one is done
+func (b *proxyBackend) retire() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retired = true
+	if b.inFlight == 0 {
+		close(b.drained)
+	}
+	return b.drained
+}
+
+// VariantName identifies the variant a decision deploys
+func VariantName(decision MovementDecision) string {
+	return decision.OS + "-" + decision.Language + "-" + decision.Format
+}
+
+var _ TrafficSwitch = (*Proxy)(nil)
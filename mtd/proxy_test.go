@@ -0,0 +1,162 @@
+package mtd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// variantServer is a backend answering with its name
+func variantServer(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// get requests path on the proxy and returns the status, the variant header and the body
+func get(t *testing.T, proxy *httptest.Server, path string) (int, string, string) {
+	t.Helper()
+	res, err := proxy.Client().Get(proxy.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	return res.StatusCode, res.Header.Get("X-MTD-Variant"), string(body)
+}
+
+func TestProxySwitchKeepsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		fmt.Fprint(w, "old")
+	}))
+	t.Cleanup(slow.Close)
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	// Runs before slow.Close, which waits for the blocked request
+	t.Cleanup(unblock)
+	next := variantServer(t, "new")
+
+	proxy := NewProxy()
+	front := httptest.NewServer(proxy)
+	t.Cleanup(front.Close)
+
+	oldDecision := MovementDecision{Port: "8080", OS: "ubuntu", Language: "go", Format: "json"}
+	newDecision := MovementDecision{Port: "8081", OS: "alpine", Language: "python", Format: "xml"}
+	drained, err := proxy.Switch(slow.URL, oldDecision)
+	if err != nil {
+		t.Fatalf("Switch: %v", err)
+	}
+	select {
+	case <-drained:
+	default:
+		t.Error("the first switch has nothing to drain but its channel is open")
+	}
+
+	type answer struct {
+		variant, body string
+		err           error
+	}
+	inFlight := make(chan answer)
+	go func() {
+		res, err := front.Client().Get(front.URL)
+		if err != nil {
+			inFlight <- answer{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		inFlight <- answer{res.Header.Get("X-MTD-Variant"), string(body), err}
+	}()
+	<-started
+
+	drained, err = proxy.Switch(next.URL, newDecision)
+	if err != nil {
+		t.Fatalf("Switch: %v", err)
+	}
+
+	// New requests go to the new backend while the old one is still busy
+	if _, variant, body := get(t, front, "/"); body != "new" || variant != VariantName(newDecision) {
+		t.Errorf("request after the switch answered by %q with variant %q, want the new backend", body, variant)
+	}
+	select {
+	case <-drained:
+		t.Error("old backend drained with a request in flight")
+	default:
+	}
+
+	unblock()
+	if got := <-inFlight; got.err != nil {
+		t.Errorf("in-flight request: %v", got.err)
+	} else if got.body != "old" || got.variant != VariantName(oldDecision) {
+		t.Errorf("in-flight request answered by %q with variant %q, want the old backend", got.body, got.variant)
+	}
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("old backend not drained after its last request")
+	}
+}
+
+func TestProxyLive(t *testing.T) {
+	proxy := NewProxy()
+	front := httptest.NewServer(proxy)
+	t.Cleanup(front.Close)
+
+	if status, _, _ := get(t, front, "/"); status != http.StatusServiceUnavailable {
+		t.Errorf("request without backend status = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+	if status, _, _ := get(t, front, LivePath); status != http.StatusServiceUnavailable {
+		t.Errorf("%s without backend status = %d, want %d", LivePath, status, http.StatusServiceUnavailable)
+	}
+
+	backend := variantServer(t, "blue")
+	decision := MovementDecision{IP: "10.0.0.1", Port: "8081", OS: "alpine", Language: "python", Format: "xml"}
+	if _, err := proxy.Switch(backend.URL, decision); err != nil {
+		t.Fatalf("Switch: %v", err)
+	}
+
+	if status, variant, body := get(t, front, "/"); status != http.StatusOK || variant != "alpine-python-xml" || body != "blue" {
+		t.Errorf("request = %d %q from variant %q, want 200 \"blue\" from alpine-python-xml", status, body, variant)
+	}
+
+	status, _, body := get(t, front, LivePath)
+	if status != http.StatusOK {
+		t.Fatalf("%s status = %d, want %d", LivePath, status, http.StatusOK)
+	}
+	var live LiveVariant
+	if err := json.Unmarshal([]byte(body), &live); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	want := LiveVariant{Variant: "alpine-python-xml", Backend: backend.URL, IP: "10.0.0.1", Port: "8081", OS: "alpine", Language: "python", Format: "xml"}
+	live.Since = time.Time{}
+	if live != want {
+		t.Errorf("live = %+v, want %+v", live, want)
+	}
+}
+
+func TestProxySwitchInvalidBackend(t *testing.T) {
+	proxy := NewProxy()
+	for _, target := range []string{"localhost:8080", "http://", "http://local host"} {
+		if _, err := proxy.Switch(target, MovementDecision{}); err == nil {
+			t.Errorf("switching to %q succeeded", target)
+		}
+	}
+	if _, ok := proxy.Live(); ok {
+		t.Error("a failed switch made a backend live")
+	}
+}
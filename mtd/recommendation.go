@@ -0,0 +1,129 @@
+package mtd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Recommendation is the movement proposed by the advisor
+type Recommendation struct {
+	SwitchLanguage string `json:"SwitchLanguage"`
+	SwitchOS       string `json:"SwitchOS"`
+	SwitchFormat   string `json:"SwitchFormat"`
+	SwitchPort     string `json:"SwitchPort"`
+	RotateIP       bool   `json:"RotateIP"`
+}
+
+// RecommendationSchema is the JSON schema of a Recommendation, for advisors that support structured output
+var RecommendationSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"SwitchLanguage": map[string]interface{}{"type": "string"},
+		"SwitchOS":       map[string]interface{}{"type": "string"},
+		"SwitchFormat":   map[string]interface{}{"type": "string"},
+		"SwitchPort":     map[string]interface{}{"type": "string"},
+		"RotateIP":       map[string]interface{}{"type": "boolean"},
+	},
+	"required": []string{"SwitchLanguage", "SwitchOS", "SwitchFormat", "SwitchPort", "RotateIP"},
+}
+
+// UnmarshalJSON accepts the loose types models often produce,
+// such as "RotateIP": "true" or "SwitchPort": 8080
+func (r *Recommendation) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		SwitchLanguage string          `json:"SwitchLanguage"`
+		SwitchOS       string          `json:"SwitchOS"`
+		SwitchFormat   string          `json:"SwitchFormat"`
+		SwitchPort     json.RawMessage `json:"SwitchPort"`
+		RotateIP       json.RawMessage `json:"RotateIP"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	port, err := looseString(raw.SwitchPort)
+	if err != nil {
+		return fmt.Errorf("SwitchPort: %w", err)
+	}
+	rotateIP, err := looseBool(raw.RotateIP)
+	if err != nil {
+		return fmt.Errorf("RotateIP: %w", err)
+	}
+
+	*r = Recommendation{
+		SwitchLanguage: raw.SwitchLanguage,
+		SwitchOS:       raw.SwitchOS,
+		SwitchFormat:   raw.SwitchFormat,
+		SwitchPort:     port,
+		RotateIP:       rotateIP,
+	}
+	return nil
+}
+
+// ParseRecommendation extracts the first JSON object holding a Recommendation from an answer,
+// ignoring any prose or markdown code fences around it
+func ParseRecommendation(answer string) (Recommendation, error) {
+	var lastErr error
+	for start := strings.IndexByte(answer, '{'); start != -1; {
+		var rec Recommendation
+		err := json.NewDecoder(strings.NewReader(answer[start:])).Decode(&rec)
+		if err == nil {
+			if rec.SwitchLanguage == "" && rec.SwitchOS == "" && rec.SwitchFormat == "" {
+				err = errors.New("JSON object has none of SwitchLanguage, SwitchOS or SwitchFormat")
+			} else {
+				return rec, nil
+			}
+		}
+		lastErr = err
+
+		next := strings.IndexByte(answer[start+1:], '{')
+		if next == -1 {
+			break
+		}
+		start += next + 1
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no JSON object found")
+	}
+	return Recommendation{}, fmt.Errorf("parsing recommendation: %w", lastErr)
+}
+
+// looseString decodes a JSON string or number as a string
+func looseString(data json.RawMessage) (string, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return s, nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return "", fmt.Errorf("expected a string or a number, got %s", data)
+	}
+	return n.String(), nil
+}
+
+// looseBool decodes a JSON boolean, or a string holding one, as a bool
+func looseBool(data json.RawMessage) (bool, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return false, nil
+	}
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return false, fmt.Errorf("expected a boolean, got %s", data)
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return false, fmt.Errorf("expected a boolean, got %q", s)
+	}
+	return b, nil
+}
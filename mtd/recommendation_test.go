@@ -0,0 +1,96 @@
+package mtd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRecommendation(t *testing.T) {
+	want := Recommendation{SwitchLanguage: "go", SwitchOS: "ubuntu", SwitchFormat: "json", SwitchPort: "8080", RotateIP: true}
+	object := `{"SwitchLanguage": "go", "SwitchOS": "ubuntu", "SwitchFormat": "json", "SwitchPort": "8080", "RotateIP": true}`
+
+	tests := []struct {
+		name    string
+		answer  string
+		want    Recommendation
+		wantErr string
+	}{
+		{name: "bare JSON", answer: object, want: want},
+		{
+			name:   "prose around the JSON",
+			answer: "Given the intrusion attempts, I recommend:\n" + object + "\nThis rotates the IP.",
+			want:   want,
+		},
+		{
+			name:   "fenced JSON",
+			answer: "```json\n" + object + "\n```",
+			want:   want,
+		},
+		{
+			name:   "RotateIP as a string",
+			answer: `{"SwitchLanguage": "go", "SwitchOS": "ubuntu", "SwitchFormat": "json", "SwitchPort": "8080", "RotateIP": "true"}`,
+			want:   want,
+		},
+		{
+			name:   "RotateIP as a false string",
+			answer: `{"SwitchLanguage": "go", "SwitchOS": "ubuntu", "SwitchFormat": "json", "SwitchPort": "8080", "RotateIP": " False "}`,
+			want:   Recommendation{SwitchLanguage: "go", SwitchOS: "ubuntu", SwitchFormat: "json", SwitchPort: "8080"},
+		},
+		{
+			name:   "RotateIP as a bool and SwitchPort as a number",
+			answer: `{"SwitchLanguage": "go", "SwitchOS": "ubuntu", "SwitchFormat": "json", "SwitchPort": 8080, "RotateIP": true}`,
+			want:   want,
+		},
+		{
+			name:   "missing fields are empty",
+			answer: `{"SwitchOS": "ubuntu"}`,
+			want:   Recommendation{SwitchOS: "ubuntu"},
+		},
+		{
+			name:   "case is kept for ConstrainDecision to repair",
+			answer: `{"SwitchLanguage": "Go", "SwitchOS": "UBUNTU", "SwitchFormat": "json", "SwitchPort": "8080", "RotateIP": true}`,
+			want:   Recommendation{SwitchLanguage: "Go", SwitchOS: "UBUNTU", SwitchFormat: "json", SwitchPort: "8080", RotateIP: true},
+		},
+		{
+			name:   "first object without a recommendation is skipped",
+			answer: `Current metrics: {"response_time_ms": 250} so ` + object,
+			want:   want,
+		},
+		{name: "empty answer", answer: "", wantErr: "no JSON object found"},
+		{name: "prose only", answer: "Move to another OS.", wantErr: "no JSON object found"},
+		{name: "empty object", answer: "{}", wantErr: "none of SwitchLanguage, SwitchOS or SwitchFormat"},
+		{
+			name:    "RotateIP not a boolean",
+			answer:  `{"SwitchLanguage": "go", "SwitchOS": "ubuntu", "SwitchFormat": "json", "SwitchPort": "8080", "RotateIP": "maybe"}`,
+			wantErr: `RotateIP: expected a boolean, got "maybe"`,
+		},
+		{
+			name:    "SwitchPort not a string or number",
+			answer:  `{"SwitchLanguage": "go", "SwitchOS": "ubuntu", "SwitchFormat": "json", "SwitchPort": ["8080"], "RotateIP": true}`,
+			wantErr: "SwitchPort: expected a string or a number",
+		},
+		{
+			name:    "truncated JSON",
+			answer:  `{"SwitchLanguage": "go", "SwitchOS": "ubu`,
+			wantErr: "parsing recommendation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRecommendation(tt.answer)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseRecommendation error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRecommendation: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRecommendation = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
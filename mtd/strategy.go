@@ -1,39 +1,38 @@
 package mtd
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
-	"math"
 	"time"
 )
 
-// Metrics represents the structure of metrics.json
+// Metrics is the measured state of the system, as read from metrics.json or collected live.
+// What the metrics are judged against is set apart in StrategySettings.
 type Metrics struct {
-	QualityOfService struct {
-		ResponseTimeMs float64 `json:"response_time_ms"`
-		ErrorRate      float64 `json:"error_rate"`
-	} `json:"quality_of_service"`
-	SecurityMetrics struct {
-		VulnerabilityCount int `json:"vulnerability_count"`
-		IntrusionAttempts  int `json:"intrusion_attempts"`
-	} `json:"security_metrics"`
-	AssetValue struct {
-		CriticalAssets  int `json:"critical_assets"`
-		HighValueAssets int `json:"high_value_assets"`
-	} `json:"asset_value"`
-	StrategySettings struct {
-		Thresholds struct {
-			ResponseTimeMs     float64 `json:"response_time_ms"`
-			ErrorRate          float64 `json:"error_rate"`
-			VulnerabilityCount int     `json:"vulnerability_count"`
-			IntrusionAttempts  int     `json:"intrusion_attempts"`
-		} `json:"thresholds"`
-		Weights struct {
-			QualityOfService float64 `json:"quality_of_service"`
-			SecurityMetrics  float64 `json:"security_metrics"`
-			AssetValue       float64 `json:"asset_value"`
-		} `json:"weights"`
-	} `json:"strategy_settings"`
+	QualityOfService QoSMetrics      `json:"quality_of_service"`
+	SecurityMetrics  SecurityMetrics `json:"security_metrics"`
+	AssetValue       AssetValue      `json:"asset_value"`
+	// Extra holds the registered metrics beyond the built-in ones, by name
+	Extra map[string]float64 `json:"extra,omitempty"`
+}
+
+// QoSMetrics is the quality of service the clients get
+type QoSMetrics struct {
+	ResponseTimeMs float64 `json:"response_time_ms"`
+	ErrorRate      float64 `json:"error_rate"`
+}
+
+// SecurityMetrics counts the threats against the system
+type SecurityMetrics struct {
+	VulnerabilityCount int `json:"vulnerability_count"`
+	IntrusionAttempts  int `json:"intrusion_attempts"`
+}
+
+// AssetValue counts the assets the system exposes
+type AssetValue struct {
+	CriticalAssets  int `json:"critical_assets"`
+	HighValueAssets int `json:"high_value_assets"`
 }
 
 // StrategyType defines the type of strategy to use
@@ -43,25 +42,88 @@ const (
 	RoundRobin StrategyType = "round_robin"
 	Random     StrategyType = "random"
 	Weighted   StrategyType = "weighted"
+	// Manual marks a movement forced by an operator rather than decided by a strategy; it is not a registered strategy
+	Manual StrategyType = "manual"
 )
 
 // MovementDecision encapsulates the decision for movement
 type MovementDecision struct {
-	IP        string
-	Port      string
-	OS        string
-	Format    string
-	Language  string
-	Strategy  StrategyType
-	Score     float64 // Used for weighted strategy
-	Timestamp time.Time
+	IP        string       `json:"ip"`
+	Port      string       `json:"port"`
+	OS        string       `json:"os"`
+	Format    string       `json:"format"`
+	Language  string       `json:"language"`
+	Strategy  StrategyType `json:"strategy"`
+	Score     float64      `json:"score,omitempty"` // Used for weighted strategy
+	Timestamp time.Time    `json:"timestamp"`
+	// Adjustments lists the proposed values that were replaced because the Config does not allow them
+	Adjustments []Adjustment `json:"adjustments,omitempty"`
+	// Reasoning records how the decision was reached, for strategies that look at more than the Config
+	Reasoning *Reasoning `json:"reasoning,omitempty"`
+}
+
+// Reasoning is the chain of evidence behind a decision
+type Reasoning struct {
+	Policies       []ScoredPolicy  `json:"policies,omitempty"`        // Policies retrieved from the knowledge base
+	Prompt         string          `json:"prompt,omitempty"`          // First prompt sent to the advisor
+	AdvisorAnswers []string        `json:"advisor_answers,omitempty"` // Raw advisor answers, one per attempt
+	Recommendation *Recommendation `json:"recommendation,omitempty"`  // Parsed advisor recommendation
+	Fallback       FallbackKind    `json:"fallback,omitempty"`        // Which step failed, empty when the advisor recommendation was used
+	FallbackReason string          `json:"fallback_reason,omitempty"` // Why the advisor recommendation was not used
 }
 
+// FallbackKind tells why a strategy could not follow its advisor
+type FallbackKind string
+
+const (
+	FallbackNoKnowledge      FallbackKind = "no_knowledge"      // No knowledge base configured
+	FallbackKnowledgeError   FallbackKind = "knowledge_error"   // The knowledge base search failed
+	FallbackNoAdvisor        FallbackKind = "no_advisor"        // No advisor configured
+	FallbackAdvisorError     FallbackKind = "advisor_error"     // The advisor could not be reached
+	FallbackUnparsableAnswer FallbackKind = "unparsable_answer" // The advisor answers held no valid recommendation
+)
+
 // Strategy defines the interface for different strategies
 type Strategy interface {
-	Decide(metrics Metrics, config Config) (MovementDecision, error)
+	Decide(ctx context.Context, metrics Metrics, config Config) (MovementDecision, error)
+}
+
+// MovementObserver is implemented by strategies that decide relative to the movement that went live.
+// Applied is called once a movement was deployed, decisions that were not are never reported.
+type MovementObserver interface {
+	Applied(decision MovementDecision)
+}
+
+// Reconfigurable is implemented by strategies whose settings can change while they keep their state
+type Reconfigurable interface {
+	Reconfigure(settings StrategySettings)
 }
 
+// Advisor recommends configuration changes for a prompt, usually backed by an LLM
+type Advisor interface {
+	Advise(ctx context.Context, prompt string) (string, error)
+}
+
+// AdvisorFunc adapts an ordinary function to the Advisor interface
+type AdvisorFunc func(ctx context.Context, prompt string) (string, error)
+
+// Advise calls f(ctx, prompt)
+func (f AdvisorFunc) Advise(ctx context.Context, prompt string) (string, error) {
+	return f(ctx, prompt)
+}
+
+// Every strategy must be interchangeable behind the Strategy interface
+var (
+	_ Strategy = (*RoundRobinStrategy)(nil)
+	_ Strategy = (*RandomStrategy)(nil)
+	_ Strategy = (*WeightedStrategy)(nil)
+
+	_ MovementObserver = (*WeightedStrategy)(nil)
+	_ Reconfigurable   = (*RoundRobinStrategy)(nil)
+	_ Reconfigurable   = (*RandomStrategy)(nil)
+	_ Reconfigurable   = (*WeightedStrategy)(nil)
+)
+
 // Config represents the configuration for strategies
 type Config struct {
 	IPs       []string `json:"ips"`
@@ -71,51 +133,44 @@ type Config struct {
 	Languages []string `json:"languages"`
 }
 
-// StrategySettings holds thresholds
+// StrategySettings is the policy the metrics are judged against
 type StrategySettings struct {
-	Thresholds struct {
-		ResponseTimeMs     float64 `json:"response_time_ms"`
-		ErrorRate          float64 `json:"error_rate"`
-		VulnerabilityCount int     `json:"vulnerability_count"`
-		IntrusionAttempts  int     `json:"intrusion_attempts"`
-	} `json:"thresholds"`
+	Thresholds Thresholds `json:"thresholds"`
+	Weights    Weights    `json:"weights"`
 }
 
-// Helper functions to calculate scores
-func calculateQoSScore(qos struct {
-	ResponseTimeMs float64 `json:"response_time_ms"`
-	ErrorRate      float64 `json:"error_rate"`
-}, thresholds struct {
+// Thresholds are the metric values a strategy compares the measured ones with
+type Thresholds struct {
 	ResponseTimeMs     float64 `json:"response_time_ms"`
 	ErrorRate          float64 `json:"error_rate"`
 	VulnerabilityCount int     `json:"vulnerability_count"`
 	IntrusionAttempts  int     `json:"intrusion_attempts"`
-}) float64 {
-	// Lower response time and error rate are better
-	responseTimeScore := math.Max(0, thresholds.ResponseTimeMs-qos.ResponseTimeMs)
-	errorRateScore := math.Max(0, thresholds.ErrorRate-qos.ErrorRate)
-	return responseTimeScore + errorRateScore
+	// Extra holds the thresholds of the registered metrics beyond the built-in ones, by name
+	Extra map[string]float64 `json:"extra,omitempty"`
 }
 
-func calculateSecurityScore(security struct {
-	VulnerabilityCount int `json:"vulnerability_count"`
-	IntrusionAttempts  int `json:"intrusion_attempts"`
-}, thresholds struct {
-	ResponseTimeMs     float64 `json:"response_time_ms"`
-	ErrorRate          float64 `json:"error_rate"`
-	VulnerabilityCount int     `json:"vulnerability_count"`
-	IntrusionAttempts  int     `json:"intrusion_attempts"`
-}) float64 {
-	// Lower counts are better
-	vulnScore := math.Max(0, float64(thresholds.VulnerabilityCount)-float64(security.VulnerabilityCount))
-	intrusionScore := math.Max(0, float64(thresholds.IntrusionAttempts)-float64(security.IntrusionAttempts))
-	return vulnScore + intrusionScore
+// Weights are the importance of each metric category in a decision
+type Weights struct {
+	QualityOfService float64 `json:"quality_of_service"`
+	SecurityMetrics  float64 `json:"security_metrics"`
+	AssetValue       float64 `json:"asset_value"`
 }
 
-func calculateAssetScore(asset struct {
-	CriticalAssets  int `json:"critical_assets"`
-	HighValueAssets int `json:"high_value_assets"`
-}) float64 {
+// nextValue returns the option following current, wrapping around, or the first option when current is not one of them
+func nextValue(current string, options []string) string {
+	if len(options) == 0 {
+		return ""
+	}
+	for i, option := range options {
+		if option == current {
+			return options[(i+1)%len(options)]
+		}
+	}
+	return options[0]
+}
+
+// calculateAssetScore scores the asset counts, which are not compared with thresholds
+func calculateAssetScore(asset AssetValue) float64 {
 	// Higher asset value increases the need for movement
 	return float64(asset.CriticalAssets*2 + asset.HighValueAssets)
 }
@@ -1,6 +1,7 @@
 package mtd
 
 import (
+	"context"
 	"errors"
 	"math/rand"
 	"time"
@@ -16,7 +17,7 @@ func NewRandomStrategy() *RandomStrategy {
 }
 
 // Decide selects the next movement randomly
-func (s *RandomStrategy) Decide(metrics Metrics, config Config) (MovementDecision, error) {
+func (s *RandomStrategy) Decide(ctx context.Context, metrics Metrics, config Config) (MovementDecision, error) {
 	if len(config.Ports) == 0 || len(config.OSes) == 0 || len(config.Formats) == 0 || len(config.Languages) == 0 {
 		return MovementDecision{}, errors.New("configuration lists cannot be empty")
 	}
@@ -29,6 +30,12 @@ func (s *RandomStrategy) Decide(metrics Metrics, config Config) (MovementDecisio
 		Strategy:  Random,
 		Timestamp: time.Now(),
 	}
+	if len(config.IPs) > 0 {
+		decision.IP = config.IPs[rand.Intn(len(config.IPs))]
+	}
 
 	return decision, nil
 }
+
+// Reconfigure does nothing, random movements ignore the settings
+func (s *RandomStrategy) Reconfigure(settings StrategySettings) {}
@@ -0,0 +1,77 @@
+package mtd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StrategyDependencies holds everything a strategy may need to be built
+type StrategyDependencies struct {
+	Knowledge KnowledgeBase
+	Advisor   Advisor
+	Settings  StrategySettings
+}
+
+// StrategyFactory builds a strategy from its dependencies
+type StrategyFactory func(deps StrategyDependencies) (Strategy, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[StrategyType]StrategyFactory{
+		RoundRobin: func(deps StrategyDependencies) (Strategy, error) {
+			return NewRoundRobinStrategy(), nil
+		},
+		Random: func(deps StrategyDependencies) (Strategy, error) {
+			return NewRandomStrategy(), nil
+		},
+		Weighted: func(deps StrategyDependencies) (Strategy, error) {
+			return NewWeightedStrategy(deps.Settings, deps.Knowledge, deps.Advisor), nil
+		},
+	}
+)
+
+// RegisterStrategy makes a strategy available under the given name, replacing any previous one
+func RegisterStrategy(kind StrategyType, factory StrategyFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[kind] = factory
+}
+
+// StrategyTypes returns the names of all registered strategies, sorted
+func StrategyTypes() []StrategyType {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	kinds := make([]StrategyType, 0, len(registry))
+	for kind := range registry {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+	return kinds
+}
+
+// ParseStrategyType returns the StrategyType for name, rejecting names that are not registered
+func ParseStrategyType(name string) (StrategyType, error) {
+	kind := StrategyType(strings.TrimSpace(name))
+
+	registryMu.RLock()
+	_, ok := registry[kind]
+	registryMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown strategy %q, available strategies: %v", name, StrategyTypes())
+	}
+	return kind, nil
+}
+
+// NewStrategy creates the strategy registered for the given type
+func NewStrategy(kind StrategyType, deps StrategyDependencies) (Strategy, error) {
+	registryMu.RLock()
+	factory, ok := registry[kind]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q, available strategies: %v", kind, StrategyTypes())
+	}
+	return factory(deps)
+}
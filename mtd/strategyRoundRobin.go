@@ -1,6 +1,7 @@
 package mtd
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -18,7 +19,7 @@ func NewRoundRobinStrategy() *RoundRobinStrategy {
 }
 
 // Decide selects the next movement using round-robin
-func (s *RoundRobinStrategy) Decide(metrics Metrics, config Config) (MovementDecision, error) {
+func (s *RoundRobinStrategy) Decide(ctx context.Context, metrics Metrics, config Config) (MovementDecision, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -34,7 +35,13 @@ func (s *RoundRobinStrategy) Decide(metrics Metrics, config Config) (MovementDec
 		Strategy:  RoundRobin,
 		Timestamp: time.Now(),
 	}
+	if len(config.IPs) > 0 {
+		decision.IP = config.IPs[s.currentIndex%len(config.IPs)]
+	}
 
 	s.currentIndex++
 	return decision, nil
 }
+
+// Reconfigure does nothing, the rotation ignores the settings and carries on where it was
+func (s *RoundRobinStrategy) Reconfigure(settings StrategySettings) {}
@@ -1,161 +1,269 @@
 package mtd
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"log"
-	"mtd-system/ollama"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/elastic/go-elasticsearch/v8"
 )
 
+// maxAdvisorAttempts is how many times the advisor is asked before its answer is given up as unparsable
+const maxAdvisorAttempts = 2
+
 // WeightedStrategy implements a weighted decision-making algorithm
 type WeightedStrategy struct {
-	weights  MetricsWeights
-	settings StrategySettings
-}
+	knowledge  KnowledgeBase
+	advisor    Advisor
+	roundRobin *RoundRobinStrategy
+	random     *RandomStrategy
 
-// MetricsWeights holds the weights for different metric categories
-type MetricsWeights struct {
-	QualityOfService float64 `json:"quality_of_service"`
-	SecurityMetrics  float64 `json:"security_metrics"`
-	AssetValue       float64 `json:"asset_value"`
+	mu       sync.Mutex
+	settings StrategySettings
+	// last is the movement that went live last, the port and IP rotate away from it
+	last MovementDecision
 }
 
-// NewWeightedStrategy creates a new WeightedStrategy
-func NewWeightedStrategy(weights MetricsWeights, settings StrategySettings) *WeightedStrategy {
+// NewWeightedStrategy creates a new WeightedStrategy.
+// knowledge and advisor are optional: without knowledge the strategy falls back to
+// a score-based decision, and without an advisor it applies the best matching policy.
+func NewWeightedStrategy(settings StrategySettings, knowledge KnowledgeBase, advisor Advisor) *WeightedStrategy {
 	return &WeightedStrategy{
-		weights:  weights,
-		settings: settings,
+		settings:   settings,
+		knowledge:  knowledge,
+		advisor:    advisor,
+		roundRobin: NewRoundRobinStrategy(),
+		random:     NewRandomStrategy(),
 	}
 }
 
-func (s *WeightedStrategy) Decide(metrics Metrics, config Config, es *elasticsearch.Client) (MovementDecision, error) {
+// Decide selects the next movement from the retrieved knowledge and the advisor recommendation
+func (s *WeightedStrategy) Decide(ctx context.Context, metrics Metrics, config Config) (MovementDecision, error) {
 	if len(config.Ports) == 0 || len(config.OSes) == 0 || len(config.Formats) == 0 || len(config.Languages) == 0 {
 		return MovementDecision{}, errors.New("configuration lists cannot be empty")
 	}
 
+	s.mu.Lock()
+	settings, last := s.settings, s.last
+	s.mu.Unlock()
+
+	if s.knowledge == nil {
+		log.Printf("No knowledge base configured, moving to a weighted decision without knowledge")
+		return s.fallbackDecide(ctx, metrics, config, settings, FallbackNoKnowledge, "no knowledge base configured")
+	}
+
 	// Fetch knowledge data
-	knowledge, err := ElasticSearch(es, metrics)
+	knowledge, err := s.knowledge.Search(ctx, metrics, settings.Weights)
 	if err != nil {
 		log.Printf("Error fetching knowledge: %v", err)
 		log.Printf("Moving to a weighted decision without elastic search knowledge")
-		return s.fallbackDecide(metrics, config)
+		return s.fallbackDecide(ctx, metrics, config, settings, FallbackKnowledgeError, fmt.Sprintf("knowledge search failed: %v", err))
 	}
+	reasoning := &Reasoning{Policies: knowledge}
 
 	var prevDecisions string
-	for _, action := range knowledge {
-		prevDecisions += fmt.Sprintf("\t\t%+v\n", action)
+	for _, match := range knowledge {
+		prevDecisions += fmt.Sprintf("\t\t(similarity %.2f) %+v\n", match.Score, match.Policy)
 	}
-	log.Printf("Best matches on Elasticsearch:\n%s", prevDecisions)
+	log.Printf("Best matches in the knowledge base:\n%s", prevDecisions)
 
 	// Construct prompt for Ollama based on decision and knowledge
 	prompt := fmt.Sprintf(`
 	You are a cloud security expert. You are good at designing and implementing secure cloud environments.
 	Your tasks is to analyze the current configuration of the cloud environment and make recommendations for improvements based on previous decisions.
 	Given the CURRENT METRICS tell me what configuration changes should be made based on PREVIOUS DECISIONS
-	Make sure that the output is in JSON format defined in OUTPUT FORMAT is valid and well-formatted.
+	Answer only with a valid JSON object in the format defined in OUTPUT FORMAT, without any other text.
 
 	CURRENT METRICS:
-	Quality of Service:
-		Response Time: %f ms
-		Error Rate: %f
-	Security Metrics:
-		Vulnerability Count: %d
-		Intrusion Attempts: %d
-	Asset Value: 
-		Critical Assets: %d
-		High Value Assets: %d
-	Strategy settings:
-		Thresholds:
-			Response Time: %f ms
-			Error Rate: %f
-			Vulnerability Count: %d
-			Intrusion Attempts: %d
-		Weights:
-			Quality of Service: %f
-			Security Metrics: %f
-			Asset Value: %f
-
+%s
 	PREVIOUS DECISIONS:
 %s
 
+	AVAILABLE CONFIGURATIONS:
+		Languages: %v
+		OSes: %v
+		Formats: %v
+		Ports: %v (current port: %s)
+	Set RotateIP to true to move the service to another IP address.
+
 	OUTPUT FORMAT:
-	{"SwitchLanguage": "python", "SwitchOS": "ubuntu", "SwitchFormat": "json", "SwitchPort": "80", "RotateIP": "true"}
-		`, metrics.QualityOfService.ResponseTimeMs, metrics.QualityOfService.ErrorRate,
-		metrics.SecurityMetrics.VulnerabilityCount, metrics.SecurityMetrics.IntrusionAttempts,
-		metrics.AssetValue.CriticalAssets, metrics.AssetValue.HighValueAssets,
-		s.settings.Thresholds.ResponseTimeMs, s.settings.Thresholds.ErrorRate,
-		s.settings.Thresholds.VulnerabilityCount, s.settings.Thresholds.IntrusionAttempts,
-		s.weights.QualityOfService, s.weights.SecurityMetrics,
-		s.weights.AssetValue, prevDecisions)
+	{"SwitchLanguage": "python", "SwitchOS": "ubuntu", "SwitchFormat": "json", "SwitchPort": "8080", "RotateIP": true}
+		`, describeMetrics(metrics, settings), prevDecisions,
+		config.Languages, config.OSes, config.Formats, config.Ports, last.Port)
 
 	// log.Printf("\nUser> \n%s", prompt)
 	// Ask Ollama for final decision
 	var oLlamaerror = false
-	ollamaAnswer, err := ollama.AskOllama(prompt)
-	if err != nil {
-		log.Printf("Error querying Ollama: %v", err)
+	var rec Recommendation
+	reasoning.Prompt = prompt
+	if s.advisor == nil {
 		oLlamaerror = true
-		log.Printf("Moving to a weighted decision using elastic search knowledge, without Ollama recommendation")
-		// return s.fallbackDecide(metrics, config)
-		// mu.Unlock()
-		// continue
+		reasoning.Fallback = FallbackNoAdvisor
+		reasoning.FallbackReason = "no advisor configured"
+		log.Printf("No advisor configured, moving to a weighted decision using elastic search knowledge")
+	} else {
+		rec, reasoning.AdvisorAnswers, err = s.askAdvisor(ctx, prompt)
+		if err != nil {
+			log.Printf("Error querying Ollama: %v", err)
+			oLlamaerror = true
+			reasoning.Fallback = FallbackAdvisorError
+			if len(reasoning.AdvisorAnswers) > 0 {
+				// The advisor answered, but never with a usable recommendation
+				reasoning.Fallback = FallbackUnparsableAnswer
+			}
+			reasoning.FallbackReason = fmt.Sprintf("advisor failed: %v", err)
+			log.Printf("Moving to a weighted decision using elastic search knowledge, without Ollama recommendation")
+		} else {
+			reasoning.Recommendation = &rec
+		}
 	}
 
-	log.Printf("\n\t\t\tOllama> %s", ollamaAnswer)
+	// The best matching policy is the fallback for anything Ollama did not propose correctly
+	best := knowledge[0].Policy.RecommendedActions
+	fallback := MovementDecision{
+		IP:       last.IP,
+		Port:     nextValue(last.Port, config.Ports),
+		OS:       best.SwitchOS,
+		Format:   best.SwitchFormat,
+		Language: best.SwitchLanguage,
+	}
+	if best.RotateIP {
+		fallback.IP = nextValue(last.IP, config.IPs)
+	}
 
-	// Parse Ollama response
-	// {SwitchLanguage: "python", SwitchOS: "ubuntu", SwitchFormat: "json", SwitchPort: "80", RotateIP: true}
-	var resp map[string]string
-	err = json.Unmarshal([]byte(ollamaAnswer), &resp)
-	if err != nil {
-		oLlamaerror = true
-		log.Printf("Error parsing Ollama response: %v", err)
-		log.Printf("Moving to a weighted decision using elastic search knowledge, without Ollama recommendation")
+	proposed := fallback
+	if !oLlamaerror {
+		proposed = MovementDecision{
+			IP:       last.IP,
+			Port:     rec.SwitchPort,
+			OS:       rec.SwitchOS,
+			Format:   rec.SwitchFormat,
+			Language: rec.SwitchLanguage,
+		}
+		if rec.RotateIP {
+			proposed.IP = nextValue(last.IP, config.IPs)
+		}
 	}
 
-	if oLlamaerror {
-		resp = make(map[string]string)
-		resp["SwitchLanguage"] = knowledge[0].RecommendedActions.SwitchLanguage
-		resp["SwitchOS"] = knowledge[0].RecommendedActions.SwitchOS
-		resp["SwitchFormat"] = knowledge[0].RecommendedActions.SwitchFormat
+	// Apply recommended actions, restricted to the available configurations
+	decision, adjustments := ConstrainDecision(proposed, fallback, config)
+	for _, adjustment := range adjustments {
+		log.Printf("Adjusted %s: %s", adjustment.Field, adjustment.Reason)
 	}
+	decision.Strategy = Weighted
+	decision.Timestamp = time.Now()
+	decision.Adjustments = adjustments
+	decision.Reasoning = reasoning
+	return decision, nil
+}
+
+// Reconfigure makes the next decisions use settings, keeping the movement they rotate away from
+func (s *WeightedStrategy) Reconfigure(settings StrategySettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings = settings
+}
+
+// Applied keeps the deployed movement as the starting point of the next decision
+func (s *WeightedStrategy) Applied(decision MovementDecision) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = decision
+}
+
+// askAdvisor asks the advisor for a recommendation, retrying with a corrective prompt
+// when the answer does not hold a valid Recommendation. It also returns every raw answer.
+func (s *WeightedStrategy) askAdvisor(ctx context.Context, prompt string) (Recommendation, []string, error) {
+	currentPrompt := prompt
+	var answers []string
+	var lastErr error
+	for attempt := 1; attempt <= maxAdvisorAttempts; attempt++ {
+		answer, err := s.advisor.Advise(ctx, currentPrompt)
+		if err != nil {
+			return Recommendation{}, answers, err
+		}
+		answers = append(answers, answer)
+		log.Printf("\n\t\t\tOllama> %s", answer)
+
+		rec, err := ParseRecommendation(answer)
+		if err == nil {
+			return rec, answers, nil
+		}
+		lastErr = err
+		log.Printf("Error parsing Ollama response (attempt %d/%d): %v", attempt, maxAdvisorAttempts, err)
 
-	// Apply recommended actions
-	decision := MovementDecision{
-		// IP:        config.IPs[rand.Intn(len(config.IPs))],
-		OS:        resp["SwitchOS"],
-		Format:    resp["SwitchFormat"],
-		Language:  resp["SwitchLanguage"],
-		Strategy:  Weighted,
-		Timestamp: time.Now(),
+		currentPrompt = fmt.Sprintf(`%s
+
+	Your previous answer could not be used: %v
+	PREVIOUS ANSWER:
+	%s
+
+	Answer again with only one JSON object with the keys SwitchLanguage, SwitchOS, SwitchFormat, SwitchPort (strings) and RotateIP (boolean).
+	`, prompt, err, answer)
 	}
+	return Recommendation{}, answers, lastErr
+}
 
-	// // Optionally, handle rotate_ip
-	// rotateIP, _ := recommendedActions["rotate_ip"].(bool)
-	// if rotateIP {
-	// 	decision.IP = config.IPs[rand.Intn(len(config.IPs))]
-	// }
+// describeMetrics lists the current metrics for the advisor, by category, each with its unit, the direction
+// it improves in and its threshold, followed by the weight of each category
+func describeMetrics(metrics Metrics, settings StrategySettings) string {
+	categories := []struct {
+		name   string
+		title  string
+		weight float64
+	}{
+		{CategoryQualityOfService, "Quality of Service", settings.Weights.QualityOfService},
+		{CategorySecurity, "Security Metrics", settings.Weights.SecurityMetrics},
+		{CategoryAssetValue, "Asset Value", settings.Weights.AssetValue},
+	}
 
-	return decision, nil
+	var b strings.Builder
+	for _, category := range categories {
+		fmt.Fprintf(&b, "\t%s:\n", category.title)
+		if category.name == CategoryAssetValue {
+			fmt.Fprintf(&b, "\t\tCritical Assets: %d\n", metrics.AssetValue.CriticalAssets)
+			fmt.Fprintf(&b, "\t\tHigh Value Assets: %d\n", metrics.AssetValue.HighValueAssets)
+		}
+		for _, def := range MetricDefinitions() {
+			value, ok := metrics.Value(def.Name)
+			if def.Category != category.name || !ok {
+				continue
+			}
+			fmt.Fprintf(&b, "\t\t%s", def.Name)
+			if def.Description != "" {
+				fmt.Fprintf(&b, " (%s)", def.Description)
+			}
+			fmt.Fprintf(&b, ": %g %s, %s", value, def.Unit, strings.ReplaceAll(string(def.Direction), "_", " "))
+			if threshold, ok := settings.Thresholds.Value(def.Name); ok {
+				fmt.Fprintf(&b, ", threshold %g", threshold)
+			}
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("\tWeights:\n")
+	for _, category := range categories {
+		fmt.Fprintf(&b, "\t\t%s: %g\n", category.title, category.weight)
+	}
+	return b.String()
 }
 
-// Decide selects the next movement based on weighted scores
-func (s *WeightedStrategy) fallbackDecide(metrics Metrics, config Config) (MovementDecision, error) {
+// fallbackDecide selects the next movement based on weighted scores, recording why it was needed
+func (s *WeightedStrategy) fallbackDecide(ctx context.Context, metrics Metrics, config Config, settings StrategySettings, kind FallbackKind, reason string) (MovementDecision, error) {
 	if len(config.Ports) == 0 || len(config.OSes) == 0 || len(config.Formats) == 0 || len(config.Languages) == 0 {
 		return MovementDecision{}, errors.New("configuration lists cannot be empty")
 	}
 
 	// Calculate scores for each category
-	qosScore := calculateQoSScore(metrics.QualityOfService, s.settings.Thresholds)
-	securityScore := calculateSecurityScore(metrics.SecurityMetrics, s.settings.Thresholds)
-	assetScore := calculateAssetScore(metrics.AssetValue)
+	thresholds := settings.Thresholds
+	qosScore := categoryScore(metrics, thresholds, CategoryQualityOfService)
+	securityScore := categoryScore(metrics, thresholds, CategorySecurity)
+	assetScore := calculateAssetScore(metrics.AssetValue) + categoryScore(metrics, thresholds, CategoryAssetValue)
 
 	// Weighted total score
-	totalScore := qosScore*s.weights.QualityOfService + securityScore*s.weights.SecurityMetrics + assetScore*s.weights.AssetValue
+	weights := settings.Weights
+	totalScore := qosScore*weights.QualityOfService + securityScore*weights.SecurityMetrics + assetScore*weights.AssetValue
 
 	// Normalize score to select a movement
 	// Higher totalScore implies higher priority to change
@@ -174,9 +282,9 @@ func (s *WeightedStrategy) fallbackDecide(metrics Metrics, config Config) (Movem
 
 	switch strategy {
 	case Random:
-		decision, err = NewRandomStrategy().Decide(metrics, config)
+		decision, err = s.random.Decide(ctx, metrics, config)
 	case RoundRobin:
-		decision, err = NewRoundRobinStrategy().Decide(metrics, config)
+		decision, err = s.roundRobin.Decide(ctx, metrics, config)
 	default:
 		err = errors.New("unknown strategy type")
 	}
@@ -187,5 +295,9 @@ func (s *WeightedStrategy) fallbackDecide(metrics Metrics, config Config) (Movem
 
 	decision.Strategy = Weighted
 	decision.Score = totalScore
+	decision.Reasoning = &Reasoning{
+		Fallback:       kind,
+		FallbackReason: fmt.Sprintf("%s, score %.2f selected the %s strategy", reason, totalScore, strategy),
+	}
 	return decision, nil
 }
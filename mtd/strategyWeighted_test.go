@@ -0,0 +1,195 @@
+package mtd
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var testConfig = Config{
+	IPs:       []string{"10.0.0.1", "10.0.0.2"},
+	Ports:     []string{"8080", "8081"},
+	OSes:      []string{"ubuntu", "alpine"},
+	Formats:   []string{"json", "xml"},
+	Languages: []string{"go", "python"},
+}
+
+var testSettings = StrategySettings{
+	Thresholds: Thresholds{ResponseTimeMs: 200, ErrorRate: 0.05, VulnerabilityCount: 10, IntrusionAttempts: 20},
+	Weights:    Weights{QualityOfService: 0.4, SecurityMetrics: 0.4, AssetValue: 0.2},
+}
+
+// testKnowledge holds a single policy recommending alpine, xml and python with a new IP
+func testKnowledge() *MemoryKnowledgeBase {
+	policy := testPolicy("best", `{"response_time_ms": 100, "error_rate": 0.01, "vulnerability_count": 5, "intrusion_attempts": 10}`)
+	policy.RecommendedActions = RecommendedActions{SwitchLanguage: "python", SwitchFormat: "xml", SwitchOS: "alpine", RotateIP: true}
+	return NewMemoryKnowledgeBase([]Policy{policy})
+}
+
+// placement is where a decision moves the service, the fields the tests compare
+type placement struct {
+	IP, Port, OS, Format, Language string
+}
+
+func placementOf(decision MovementDecision) placement {
+	return placement{decision.IP, decision.Port, decision.OS, decision.Format, decision.Language}
+}
+
+// answering returns an advisor that always gives answer
+func answering(answer string) Advisor {
+	return AdvisorFunc(func(ctx context.Context, prompt string) (string, error) {
+		return answer, nil
+	})
+}
+
+// answeringInTurn returns an advisor giving the answers one after the other, then the last one again
+func answeringInTurn(answers ...string) Advisor {
+	var asked int
+	return AdvisorFunc(func(ctx context.Context, prompt string) (string, error) {
+		answer := answers[min(asked, len(answers)-1)]
+		asked++
+		return answer, nil
+	})
+}
+
+func TestWeightedStrategyDecide(t *testing.T) {
+	// The best policy applied as is, from a system that never moved
+	policyDecision := placement{IP: "10.0.0.1", Port: "8080", OS: "alpine", Format: "xml", Language: "python"}
+
+	tests := []struct {
+		name    string
+		advisor Advisor
+		want    placement
+		// wantAnswers is how many advisor answers the reasoning holds
+		wantAnswers  int
+		wantFallback FallbackKind
+	}{
+		{
+			name:        "advisor recommendation",
+			advisor:     answering(`{"SwitchLanguage": "go", "SwitchOS": "ubuntu", "SwitchFormat": "json", "SwitchPort": "8081", "RotateIP": "false"}`),
+			want:        placement{Port: "8081", OS: "ubuntu", Format: "json", Language: "go"},
+			wantAnswers: 1,
+		},
+		{
+			name:        "recommendation outside the configuration",
+			advisor:     answering(`{"SwitchLanguage": "rust", "SwitchOS": "Ubuntu", "SwitchFormat": "yaml", "SwitchPort": "80"}`),
+			want:        placement{Port: "8080", OS: "ubuntu", Format: "xml", Language: "python"},
+			wantAnswers: 1,
+		},
+		{
+			name:        "answer with prose around the JSON",
+			advisor:     answering("I recommend:\n```json\n{\"SwitchLanguage\": \"go\", \"SwitchOS\": \"ubuntu\", \"SwitchFormat\": \"json\", \"SwitchPort\": 8081, \"RotateIP\": true}\n```"),
+			want:        placement{IP: "10.0.0.1", Port: "8081", OS: "ubuntu", Format: "json", Language: "go"},
+			wantAnswers: 1,
+		},
+		{
+			name:        "answer repaired on the second attempt",
+			advisor:     answeringInTurn("I would move to another OS.", `{"SwitchLanguage": "go", "SwitchOS": "ubuntu", "SwitchFormat": "json", "SwitchPort": "8081"}`),
+			want:        placement{Port: "8081", OS: "ubuntu", Format: "json", Language: "go"},
+			wantAnswers: 2,
+		},
+		{
+			name:         "unparsable answer",
+			advisor:      answering("I would move to another OS."),
+			want:         policyDecision,
+			wantAnswers:  maxAdvisorAttempts,
+			wantFallback: FallbackUnparsableAnswer,
+		},
+		{
+			name: "advisor error",
+			advisor: AdvisorFunc(func(ctx context.Context, prompt string) (string, error) {
+				return "", errors.New("connection refused")
+			}),
+			want:         policyDecision,
+			wantFallback: FallbackAdvisorError,
+		},
+		{
+			name:         "no advisor",
+			want:         policyDecision,
+			wantFallback: FallbackNoAdvisor,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := NewWeightedStrategy(testSettings, testKnowledge(), tt.advisor)
+			decision, err := strategy.Decide(context.Background(), testMetrics, testConfig)
+			if err != nil {
+				t.Fatalf("Decide: %v", err)
+			}
+			if got := placementOf(decision); got != tt.want {
+				t.Errorf("decision = %+v, want %+v", got, tt.want)
+			}
+			if decision.Strategy != Weighted {
+				t.Errorf("strategy = %s, want %s", decision.Strategy, Weighted)
+			}
+
+			reasoning := decision.Reasoning
+			if reasoning == nil {
+				t.Fatal("decision has no reasoning")
+			}
+			if len(reasoning.Policies) != 1 || reasoning.Policies[0].Policy.PolicyName != "best" {
+				t.Errorf("reasoning policies = %+v, want the best policy", reasoning.Policies)
+			}
+			if len(reasoning.AdvisorAnswers) != tt.wantAnswers {
+				t.Errorf("got %d advisor answers, want %d", len(reasoning.AdvisorAnswers), tt.wantAnswers)
+			}
+			if reasoning.Fallback != tt.wantFallback {
+				t.Errorf("fallback = %q, want %q", reasoning.Fallback, tt.wantFallback)
+			}
+			if (reasoning.FallbackReason != "") != (tt.wantFallback != "") {
+				t.Errorf("fallback reason = %q with fallback %q", reasoning.FallbackReason, reasoning.Fallback)
+			}
+			if (reasoning.Recommendation != nil) != (tt.wantFallback == "") {
+				t.Errorf("recommendation = %+v with fallback %q", reasoning.Recommendation, reasoning.Fallback)
+			}
+		})
+	}
+}
+
+func TestWeightedStrategyWithoutKnowledge(t *testing.T) {
+	strategy := NewWeightedStrategy(testSettings, nil, answering("{}"))
+	decision, err := strategy.Decide(context.Background(), testMetrics, testConfig)
+	if err != nil {
+		t.Fatalf("Decide: %v", err)
+	}
+	if decision.Strategy != Weighted {
+		t.Errorf("strategy = %s, want %s", decision.Strategy, Weighted)
+	}
+	if decision.Reasoning == nil || decision.Reasoning.Fallback != FallbackNoKnowledge {
+		t.Errorf("reasoning = %+v, want the %s fallback", decision.Reasoning, FallbackNoKnowledge)
+	}
+
+	if _, err := strategy.Decide(context.Background(), testMetrics, Config{}); err == nil {
+		t.Error("deciding with an empty config succeeded")
+	}
+}
+
+func TestWeightedStrategyRotatesFromAppliedMovement(t *testing.T) {
+	strategy := NewWeightedStrategy(testSettings, testKnowledge(), nil)
+	first := placement{IP: "10.0.0.1", Port: "8080", OS: "alpine", Format: "xml", Language: "python"}
+
+	// A decision that was never deployed is not the starting point of the next one
+	for i := 0; i < 2; i++ {
+		decision, err := strategy.Decide(context.Background(), testMetrics, testConfig)
+		if err != nil {
+			t.Fatalf("Decide: %v", err)
+		}
+		if got := placementOf(decision); got != first {
+			t.Fatalf("decision %d = %+v, want %+v", i, got, first)
+		}
+	}
+
+	decision, _ := strategy.Decide(context.Background(), testMetrics, testConfig)
+	strategy.Applied(decision)
+	// New settings keep the applied movement
+	strategy.Reconfigure(StrategySettings{Weights: Weights{QualityOfService: 1}})
+	decision, err := strategy.Decide(context.Background(), testMetrics, testConfig)
+	if err != nil {
+		t.Fatalf("Decide: %v", err)
+	}
+	want := placement{IP: "10.0.0.2", Port: "8081", OS: "alpine", Format: "xml", Language: "python"}
+	if got := placementOf(decision); got != want {
+		t.Errorf("decision after applying %+v = %+v, want %+v", first, got, want)
+	}
+}
@@ -0,0 +1,80 @@
+package mtd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Adjustment records a field of a proposed movement that was not allowed by the Config
+// and the value that replaced it
+type Adjustment struct {
+	Field    string `json:"field"`
+	Proposed string `json:"proposed"`
+	Applied  string `json:"applied"`
+	Reason   string `json:"reason"`
+}
+
+// ConstrainDecision checks every field of a proposed movement against the Config.
+// Values that only differ in case or surrounding spaces are repaired; invalid values are
+// replaced by the fallback value when it is allowed, otherwise by the first allowed value,
+// so the result is deterministic and never names a configuration that does not exist.
+// IP is optional: an empty proposal keeps the current IP.
+func ConstrainDecision(proposed, fallback MovementDecision, config Config) (MovementDecision, []Adjustment) {
+	decision := proposed
+	var adjustments []Adjustment
+
+	constrain := func(field, proposedValue, fallbackValue string, allowed []string, optional bool) string {
+		if optional && proposedValue == "" {
+			return ""
+		}
+		value, ok := matchAllowed(proposedValue, allowed)
+		if ok {
+			if value != proposedValue {
+				adjustments = append(adjustments, Adjustment{
+					Field:    field,
+					Proposed: proposedValue,
+					Applied:  value,
+					Reason:   "normalized to the configured value",
+				})
+			}
+			return value
+		}
+
+		reason := fmt.Sprintf("%q is not a configured %s %v", proposedValue, field, allowed)
+		if proposedValue == "" {
+			reason = fmt.Sprintf("no %s proposed", field)
+		}
+		if value, ok := matchAllowed(fallbackValue, allowed); ok {
+			adjustments = append(adjustments, Adjustment{Field: field, Proposed: proposedValue, Applied: value, Reason: reason + ", using the fallback"})
+			return value
+		}
+		if optional || len(allowed) == 0 {
+			adjustments = append(adjustments, Adjustment{Field: field, Proposed: proposedValue, Reason: reason + ", dropped"})
+			return ""
+		}
+		adjustments = append(adjustments, Adjustment{Field: field, Proposed: proposedValue, Applied: allowed[0], Reason: reason + ", using the first configured value"})
+		return allowed[0]
+	}
+
+	decision.IP = constrain("ip", proposed.IP, fallback.IP, config.IPs, true)
+	decision.Port = constrain("port", proposed.Port, fallback.Port, config.Ports, false)
+	decision.OS = constrain("os", proposed.OS, fallback.OS, config.OSes, false)
+	decision.Format = constrain("format", proposed.Format, fallback.Format, config.Formats, false)
+	decision.Language = constrain("language", proposed.Language, fallback.Language, config.Languages, false)
+
+	return decision, adjustments
+}
+
+// matchAllowed returns the allowed value equal to value, ignoring case and surrounding spaces
+func matchAllowed(value string, allowed []string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", false
+	}
+	for _, candidate := range allowed {
+		if strings.EqualFold(value, candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,145 @@
+package mtd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConstrainDecision(t *testing.T) {
+	fallback := MovementDecision{IP: "10.0.0.2", Port: "8081", OS: "alpine", Format: "xml", Language: "python"}
+	valid := MovementDecision{IP: "10.0.0.1", Port: "8080", OS: "ubuntu", Format: "json", Language: "go"}
+
+	tests := []struct {
+		name     string
+		proposed MovementDecision
+		fallback MovementDecision
+		config   Config
+		want     placement
+		// wantReasons holds, for each adjusted field, a part of the recorded reason
+		wantReasons map[string]string
+	}{
+		{
+			name:     "valid proposal",
+			proposed: valid,
+			fallback: fallback,
+			config:   testConfig,
+			want:     placementOf(valid),
+		},
+		{
+			name:     "case and spaces are normalized",
+			proposed: MovementDecision{IP: " 10.0.0.1", Port: "8080 ", OS: "Ubuntu", Format: "JSON", Language: "Go"},
+			fallback: fallback,
+			config:   testConfig,
+			want:     placementOf(valid),
+			wantReasons: map[string]string{
+				"ip":       "normalized",
+				"port":     "normalized",
+				"os":       "normalized",
+				"format":   "normalized",
+				"language": "normalized",
+			},
+		},
+		{
+			name:     "invalid values use the fallback",
+			proposed: MovementDecision{IP: "10.9.9.9", Port: "9999", OS: "windows", Format: "yaml", Language: "rust"},
+			fallback: fallback,
+			config:   testConfig,
+			want:     placementOf(fallback),
+			wantReasons: map[string]string{
+				"ip":       `"10.9.9.9" is not a configured ip [10.0.0.1 10.0.0.2], using the fallback`,
+				"port":     `"9999" is not a configured port [8080 8081], using the fallback`,
+				"os":       `"windows" is not a configured os [ubuntu alpine], using the fallback`,
+				"format":   `"yaml" is not a configured format [json xml], using the fallback`,
+				"language": `"rust" is not a configured language [go python], using the fallback`,
+			},
+		},
+		{
+			name:     "empty values use the fallback, but an empty IP is kept",
+			proposed: MovementDecision{},
+			fallback: fallback,
+			config:   testConfig,
+			want:     placement{Port: "8081", OS: "alpine", Format: "xml", Language: "python"},
+			wantReasons: map[string]string{
+				"port":     "no port proposed, using the fallback",
+				"os":       "no os proposed, using the fallback",
+				"format":   "no format proposed, using the fallback",
+				"language": "no language proposed, using the fallback",
+			},
+		},
+		{
+			name:     "invalid fallback uses the first configured value",
+			proposed: MovementDecision{Port: "9999", OS: "ubuntu", Format: "json", Language: "go"},
+			fallback: MovementDecision{Port: "7777", OS: "windows"},
+			config:   testConfig,
+			want:     placement{Port: "8080", OS: "ubuntu", Format: "json", Language: "go"},
+			wantReasons: map[string]string{
+				"port": "using the first configured value",
+			},
+		},
+		{
+			name:     "invalid IP without a valid fallback is dropped",
+			proposed: MovementDecision{IP: "10.9.9.9", Port: "8080", OS: "ubuntu", Format: "json", Language: "go"},
+			config:   testConfig,
+			want:     placement{Port: "8080", OS: "ubuntu", Format: "json", Language: "go"},
+			wantReasons: map[string]string{
+				"ip": `"10.9.9.9" is not a configured ip [10.0.0.1 10.0.0.2], dropped`,
+			},
+		},
+		{
+			name:     "no configured IPs drops the IP",
+			proposed: valid,
+			fallback: fallback,
+			config:   Config{Ports: testConfig.Ports, OSes: testConfig.OSes, Formats: testConfig.Formats, Languages: testConfig.Languages},
+			want:     placement{Port: "8080", OS: "ubuntu", Format: "json", Language: "go"},
+			wantReasons: map[string]string{
+				"ip": "dropped",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, adjustments := ConstrainDecision(tt.proposed, tt.fallback, tt.config)
+			if got := placementOf(decision); got != tt.want {
+				t.Errorf("decision = %+v, want %+v", got, tt.want)
+			}
+
+			if len(adjustments) != len(tt.wantReasons) {
+				t.Errorf("got %d adjustments %+v, want %d", len(adjustments), adjustments, len(tt.wantReasons))
+			}
+			for _, adjustment := range adjustments {
+				reason, ok := tt.wantReasons[adjustment.Field]
+				if !ok {
+					t.Errorf("unexpected adjustment of %s: %+v", adjustment.Field, adjustment)
+					continue
+				}
+				if !strings.Contains(adjustment.Reason, reason) {
+					t.Errorf("%s adjusted because %q, want %q", adjustment.Field, adjustment.Reason, reason)
+				}
+				if applied := placementField(decision, adjustment.Field); adjustment.Applied != applied {
+					t.Errorf("%s adjustment applied %q, the decision has %q", adjustment.Field, adjustment.Applied, applied)
+				}
+				if proposed := placementField(tt.proposed, adjustment.Field); adjustment.Proposed != proposed {
+					t.Errorf("%s adjustment proposed %q, the proposal has %q", adjustment.Field, adjustment.Proposed, proposed)
+				}
+			}
+		})
+	}
+}
+
+// placementField returns the field of a decision named as in the adjustments
+func placementField(decision MovementDecision, field string) string {
+	switch field {
+	case "ip":
+		return decision.IP
+	case "port":
+		return decision.Port
+	case "os":
+		return decision.OS
+	case "format":
+		return decision.Format
+	case "language":
+		return decision.Language
+	}
+	return ""
+}
@@ -2,33 +2,116 @@ package ollama
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/teilomillet/gollm"
 )
 
-// AskOllama sends a prompt to Ollama and retrieves the response
-func AskOllama(promptStr string) (string, error) {
-	// return ollamaResponse.Answer, nil
+// Config holds the settings of the Ollama advisor
+type Config struct {
+	Endpoint    string        // Base URL of the Ollama API
+	Model       string        // Model used to answer the prompts
+	Temperature float64       // Sampling temperature, lower is more deterministic
+	Timeout     time.Duration // Maximum duration of a single attempt
+	MaxRetries  int           // Attempts made after the first one fails
+	RetryDelay  time.Duration // Pause between attempts
+	// Format is passed as Ollama's structured output format: "json" or a JSON schema; nil leaves the output free
+	Format interface{}
+}
+
+// DefaultConfig returns the settings used when none are given
+func DefaultConfig() Config {
+	return Config{
+		Endpoint:    "http://localhost:11434",
+		Model:       "llama3:latest",
+		Temperature: 0.2,
+		Timeout:     2 * time.Minute,
+		MaxRetries:  1,
+		RetryDelay:  2 * time.Second,
+	}
+}
+
+// Client asks Ollama for recommendations. It implements mtd.Advisor.
+type Client struct {
+	config Config
+	llm    gollm.LLM
+}
+
+// NewClient creates an Ollama client
+func NewClient(config Config) (*Client, error) {
+	if config.Model == "" {
+		return nil, errors.New("ollama model is required")
+	}
+
 	llm, err := gollm.NewLLM(
 		gollm.SetProvider("ollama"),
-		gollm.SetModel("llama3:latest"),
+		gollm.SetModel(config.Model),
+		gollm.SetOllamaEndpoint(config.Endpoint),
+		gollm.SetTemperature(config.Temperature),
 		gollm.SetDebugLevel(gollm.LogLevelWarn),
 	)
 	if err != nil {
-		log.Fatalf("Failed to create LLM: %v", err)
+		return nil, fmt.Errorf("creating LLM: %w", err)
 	}
 
-	// Create a prompt using NewPrompt function
-	prompt := gollm.NewPrompt(promptStr)
+	// Ollama reads sampling parameters from "options"
+	llm.SetOption("options", map[string]interface{}{"temperature": config.Temperature})
+	if config.Format != nil {
+		llm.SetOption("format", config.Format)
+	}
 
-	// Generate a response
-	ctx := context.Background()
-	response, err := llm.Generate(ctx, prompt)
-	if err != nil {
-		log.Fatalf("Failed to generate response: %v", err)
+	return &Client{
+		config: config,
+		llm:    llm,
+	}, nil
+}
+
+// Advise sends a prompt to Ollama and retrieves the response.
+// Every attempt is bounded by the configured timeout, failed attempts are retried
+// up to MaxRetries times, and the whole call stops as soon as ctx is done.
+func (c *Client) Advise(ctx context.Context, prompt string) (string, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retrying Ollama (attempt %d/%d) after error: %v", attempt+1, c.config.MaxRetries+1, lastErr)
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(c.config.RetryDelay):
+			}
+		}
+
+		response, err := c.generate(ctx, prompt)
+		if err == nil {
+			return response, nil
+		}
+		lastErr = err
+
+		// Do not retry once the caller gave up
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 	}
+	return "", fmt.Errorf("querying %s on %s: %w", c.config.Model, c.config.Endpoint, lastErr)
+}
 
-	return response, nil
+// generate makes a single attempt, bounded by the configured timeout
+func (c *Client) generate(ctx context.Context, prompt string) (string, error) {
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
 
+	response, err := c.llm.Generate(ctx, gollm.NewPrompt(prompt))
+	if err != nil {
+		return "", err
+	}
+	if response == "" {
+		return "", errors.New("empty response")
+	}
+	return response, nil
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mtd-system/mtd"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// reload replaces the move space, the metric definitions and the strategy settings the next decisions are made with.
+// An invalid config is refused and the previous one is kept.
+func (c *controller) reload(config ControllerConfig) (err error) {
+	if c.telemetry != nil {
+		defer func() { c.telemetry.configReloads.Inc(result(err)) }()
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	strategy, policy := c.strategy, c.policy
+	c.mu.Unlock()
+
+	if config.Strategy.Name != policy.Name {
+		return fmt.Errorf("strategy.name cannot change from %s to %s without a restart", policy.Name, config.Strategy.Name)
+	}
+	// The strategy takes the new settings in place so that it keeps its place in the rotation,
+	// only a strategy that cannot is rebuilt
+	changed := !reflect.DeepEqual(config.Strategy, policy)
+	settings := config.Strategy.StrategySettings
+	reconfigurable, canReconfigure := strategy.(mtd.Reconfigurable)
+	if changed && !canReconfigure {
+		deps := c.deps
+		deps.Settings = settings
+		strategy, err = mtd.NewStrategy(config.Strategy.Name, deps)
+		if err != nil {
+			return fmt.Errorf("creating strategy: %w", err)
+		}
+	}
+
+	// The definitions are swapped all at once, so a failure leaves the previous ones in place
+	if err := registerMetrics(config.Metrics.Definitions); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if changed && canReconfigure {
+		reconfigurable.Reconfigure(settings)
+	}
+	c.strategy = strategy
+	c.config = config.MoveSpace
+	c.policy = config.Strategy
+	c.mu.Unlock()
+
+	// Actuators such as blue/green pick their side ports from the move space
+	if aware, ok := c.actuator.(mtd.MoveSpaceAware); ok {
+		aware.UseMoveSpace(config.MoveSpace)
+	}
+	return nil
+}
+
+// watchConfig reloads the config on SIGHUP and whenever the file at path changes, checking it every interval
+// (never when interval is 0), until ctx is cancelled. load reads the config the same way it was read at startup.
+func watchConfig(ctx context.Context, c *controller, path string, interval time.Duration, current ControllerConfig, load func() (ControllerConfig, error)) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	modified := modTime(path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			log.Printf("SIGHUP received, reloading %s", path)
+		case <-tick:
+			changed := modTime(path)
+			if changed.Equal(modified) {
+				continue
+			}
+			modified = changed
+			log.Printf("%s changed, reloading it", path)
+		}
+
+		config, err := load()
+		if err == nil {
+			err = c.reload(config)
+		}
+		if err != nil {
+			log.Printf("Error reloading %s, keeping the previous config:\n%v", path, err)
+			continue
+		}
+		for _, section := range restartSections(current, config) {
+			log.Printf("Config section %s changed, it only applies after a restart", section)
+		}
+		current = config
+		log.Printf("Config reloaded, the next decision uses the new move space and strategy settings")
+	}
+}
+
+// restartSections returns the JSON names of the sections that differ between two configs
+// and are only read at startup: all but move_space, strategy and the metric definitions
+func restartSections(previous, next ControllerConfig) []string {
+	previous.Metrics.Definitions, next.Metrics.Definitions = nil, nil
+	var sections []string
+	before, after := reflect.ValueOf(previous), reflect.ValueOf(next)
+	for i := 0; i < before.NumField(); i++ {
+		name := before.Type().Field(i).Tag.Get("json")
+		if name == "move_space" || name == "strategy" {
+			continue
+		}
+		if !reflect.DeepEqual(before.Field(i).Interface(), after.Field(i).Interface()) {
+			sections = append(sections, name)
+		}
+	}
+	return sections
+}
+
+// modTime returns when the file at path was last modified, zero when it cannot be read
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"mtd-system/mtd"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// settingsStrategy decides the first port of the move space and records the settings it decided with
+type settingsStrategy struct {
+	mu       sync.Mutex
+	settings mtd.StrategySettings
+	decided  []mtd.StrategySettings
+}
+
+func (s *settingsStrategy) Decide(ctx context.Context, metrics mtd.Metrics, config mtd.Config) (mtd.MovementDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decided = append(s.decided, s.settings)
+	return mtd.MovementDecision{Port: config.Ports[0], OS: config.OSes[0], Format: config.Formats[0], Language: config.Languages[0]}, nil
+}
+
+func (s *settingsStrategy) Reconfigure(settings mtd.StrategySettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings = settings
+}
+
+// reloadableController returns a test controller whose current config is config
+func reloadableController(t *testing.T, config ControllerConfig) (*controller, *recordingActuator) {
+	t.Helper()
+	c, actuator := newTestController(t)
+	c.config = config.MoveSpace
+	c.policy = config.Strategy
+	return c, actuator
+}
+
+func TestReloadRefused(t *testing.T) {
+	current := validConfig()
+	current.Strategy.Name = mtd.RoundRobin
+
+	tests := []struct {
+		name   string
+		change func(c *ControllerConfig)
+		want   string
+	}{
+		{name: "invalid config", change: func(c *ControllerConfig) { c.MoveSpace.Ports = nil }, want: "move_space.ports is empty"},
+		{name: "strategy name", change: func(c *ControllerConfig) { c.Strategy.Name = mtd.Random }, want: "strategy.name cannot change"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, actuator := reloadableController(t, current)
+			strategy := c.strategy
+
+			next := validConfig()
+			next.Strategy.Name = mtd.RoundRobin
+			next.MoveSpace.Ports = []string{"9090"}
+			next.Strategy.Weights = mtd.Weights{QualityOfService: 1}
+			tt.change(&next)
+
+			err := c.reload(next)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("reload error = %v, want %s", err, tt.want)
+			}
+			if c.strategy != strategy || !reflect.DeepEqual(c.config, current.MoveSpace) || !reflect.DeepEqual(c.policy, current.Strategy) {
+				t.Errorf("refused reload replaced the config: strategy %T, move space %+v, policy %+v", c.strategy, c.config, c.policy)
+			}
+			if spaces := actuator.moveSpaces(); len(spaces) != 0 {
+				t.Errorf("refused reload gave the actuator the move spaces %+v", spaces)
+			}
+		})
+	}
+}
+
+func TestReloadSettingsReachDecide(t *testing.T) {
+	current := validConfig()
+	c, actuator := reloadableController(t, current)
+	strategy := &settingsStrategy{settings: current.Strategy.StrategySettings}
+	c.strategy = strategy
+
+	next := validConfig()
+	next.MoveSpace.Ports = []string{"9090", "9091"}
+	next.Strategy.Weights = mtd.Weights{QualityOfService: 0.2, SecurityMetrics: 0.7, AssetValue: 0.1}
+	next.Strategy.Thresholds.ResponseTimeMs = 150
+	if err := c.reload(next); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if err := c.move(context.Background()); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+
+	if c.strategy != strategy {
+		t.Errorf("a strategy taking the new settings in place was rebuilt as %T", c.strategy)
+	}
+	if len(strategy.decided) != 1 || !reflect.DeepEqual(strategy.decided[0], next.Strategy.StrategySettings) {
+		t.Errorf("decided with the settings %+v, want %+v", strategy.decided, next.Strategy.StrategySettings)
+	}
+	if applied := actuator.decisions(); len(applied) != 1 || applied[0].Port != "9090" {
+		t.Errorf("applied %+v, want a movement in the reloaded move space", applied)
+	}
+	if spaces := actuator.moveSpaces(); len(spaces) != 1 || !reflect.DeepEqual(spaces[0], next.MoveSpace) {
+		t.Errorf("actuator given the move spaces %+v, want the reloaded one", spaces)
+	}
+}
+
+func TestReloadKeepsRotation(t *testing.T) {
+	current := validConfig()
+	current.Strategy.Name = mtd.RoundRobin
+	c, actuator := reloadableController(t, current)
+	strategy := c.strategy
+
+	ctx := context.Background()
+	if err := c.move(ctx); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	next := current
+	next.MoveSpace.Ports = []string{"9090", "9091", "9092"}
+	next.Strategy.Weights = mtd.Weights{QualityOfService: 1}
+	if err := c.reload(next); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if err := c.move(ctx); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+
+	if c.strategy != strategy {
+		t.Errorf("round robin rebuilt on reload")
+	}
+	applied := actuator.decisions()
+	if len(applied) != 2 || applied[0].Port != "8080" || applied[1].Port != "9091" {
+		t.Errorf("applied %+v, want the rotation to carry on at the second port of the reloaded move space", applied)
+	}
+}
@@ -0,0 +1,323 @@
+// Package telemetry exposes counters, gauges and histograms in the Prometheus text format.
+// It covers what the MTD controller needs without depending on the Prometheus client library.
+package telemetry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram upper bounds, in seconds, suited to network calls and LLM answers
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// metric is a family of series that can write itself in the text format
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families and serves them on /metrics
+type Registry struct {
+	mu      sync.Mutex
+	names   map[string]bool
+	metrics []metric
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("telemetry: metric %s registered twice", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter registers a counter family with the given label names.
+// A counter without labels is exposed at 0 until it is first incremented.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{family: newFamily(name, help, "counter", labels)}
+	if len(labels) == 0 {
+		c.Add(0)
+	}
+	r.register(name, c)
+	return c
+}
+
+// Gauge registers a gauge family with the given label names
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{family: newFamily(name, help, "gauge", labels)}
+	r.register(name, g)
+	return g
+}
+
+// Histogram registers a histogram family with the given upper bounds, DefaultBuckets when nil
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &Histogram{family: newFamily(name, help, "histogram", labels), buckets: sorted}
+	r.register(name, h)
+	return h
+}
+
+// WriteTo writes every metric in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	counter := &countingWriter{w: w}
+	buffered := bufio.NewWriter(counter)
+	for _, m := range metrics {
+		m.write(buffered)
+	}
+	err := buffered.Flush()
+	return counter.n, err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// family holds what every metric type shares: its name, help, type and label names
+type family struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func newFamily(name, help, kind string, labels []string) family {
+	return family{name: name, help: help, kind: kind, labels: labels}
+}
+
+// key identifies a series by its label values
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("telemetry: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (f *family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// labelPairs formats the label names and values, with an extra pair when extraName is set
+func (f *family) labelPairs(values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range f.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, escapeLabel(extraValue)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// series is a value with its label values
+type series struct {
+	values []string
+	value  float64
+}
+
+// sortedSeries returns the series ordered by label values, for a stable output
+func sortedSeries(m map[string]*series) []*series {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]*series, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, m[key])
+	}
+	return out
+}
+
+// Counter is a family of values that only go up
+type Counter struct {
+	family
+	series map[string]*series
+}
+
+// Inc adds 1 to the series with the given label values
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds a positive delta to the series with the given label values
+func (c *Counter) Add(delta float64, labels ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("telemetry: counter %s cannot decrease", c.name))
+	}
+	key := c.key(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.series == nil {
+		c.series = make(map[string]*series)
+	}
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), labels...)}
+		c.series[key] = s
+	}
+	s.value += delta
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, s := range sortedSeries(c.series) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(s.values, "", ""), formatValue(s.value))
+	}
+}
+
+// Gauge is a family of values that can go up and down
+type Gauge struct {
+	family
+	series map[string]*series
+}
+
+// Set sets the series with the given label values
+func (g *Gauge) Set(value float64, labels ...string) {
+	key := g.key(labels)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.series == nil {
+		g.series = make(map[string]*series)
+	}
+	g.series[key] = &series{values: append([]string(nil), labels...), value: value}
+}
+
+// Reset removes every series, e.g. before setting the one describing the current state
+func (g *Gauge) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.series = nil
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w)
+	for _, s := range sortedSeries(g.series) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(s.values, "", ""), formatValue(s.value))
+	}
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	family
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // Observations per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Observe records a value in the series with the given label values
+func (h *Histogram) Observe(value float64, labels ...string) {
+	key := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.series == nil {
+		h.series = make(map[string]*histogramSeries)
+	}
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: append([]string(nil), labels...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.values, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.values, "", ""), s.count)
+	}
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// countingWriter counts the bytes written, for WriteTo
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
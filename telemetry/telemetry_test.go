@@ -0,0 +1,203 @@
+package telemetry
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// output returns what the registry writes
+func output(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n != int64(b.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, b.Len())
+	}
+	return b.String()
+}
+
+func TestWriteTo(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(r *Registry)
+		want   string
+	}{
+		{
+			name: "counter",
+			record: func(r *Registry) {
+				c := r.Counter("mtd_decisions_total", "Decisions made.")
+				c.Inc()
+				c.Add(2.5)
+			},
+			want: `# HELP mtd_decisions_total Decisions made.
+# TYPE mtd_decisions_total counter
+mtd_decisions_total 3.5
+`,
+		},
+		{
+			name: "counter without labels starts at 0",
+			record: func(r *Registry) {
+				r.Counter("mtd_reloads_total", "Config reloads.")
+			},
+			want: `# HELP mtd_reloads_total Config reloads.
+# TYPE mtd_reloads_total counter
+mtd_reloads_total 0
+`,
+		},
+		{
+			name: "labelled gauge",
+			record: func(r *Registry) {
+				g := r.Gauge("mtd_active_variant", "Variant receiving the traffic.", "os", "language")
+				g.Set(1, "ubuntu", "go")
+				g.Set(0, "alpine", "python")
+				g.Set(1, "alpine", "python")
+			},
+			want: `# HELP mtd_active_variant Variant receiving the traffic.
+# TYPE mtd_active_variant gauge
+mtd_active_variant{os="alpine",language="python"} 1
+mtd_active_variant{os="ubuntu",language="go"} 1
+`,
+		},
+		{
+			name: "reset gauge",
+			record: func(r *Registry) {
+				g := r.Gauge("mtd_active_variant", "Variant receiving the traffic.", "os")
+				g.Set(1, "ubuntu")
+				g.Reset()
+				g.Set(1, "alpine")
+			},
+			want: `# HELP mtd_active_variant Variant receiving the traffic.
+# TYPE mtd_active_variant gauge
+mtd_active_variant{os="alpine"} 1
+`,
+		},
+		{
+			name: "histogram",
+			record: func(r *Registry) {
+				h := r.Histogram("mtd_actuation_seconds", "Time to deploy a movement.", []float64{1, 0.5, 2.5}, "result")
+				h.Observe(0.5, "success") // On a bound, counted in its bucket
+				h.Observe(0.7, "success")
+				h.Observe(10, "success") // Above the last bound, only in +Inf
+				h.Observe(0.1, "error")
+			},
+			want: `# HELP mtd_actuation_seconds Time to deploy a movement.
+# TYPE mtd_actuation_seconds histogram
+mtd_actuation_seconds_bucket{result="error",le="0.5"} 1
+mtd_actuation_seconds_bucket{result="error",le="1"} 1
+mtd_actuation_seconds_bucket{result="error",le="2.5"} 1
+mtd_actuation_seconds_bucket{result="error",le="+Inf"} 1
+mtd_actuation_seconds_sum{result="error"} 0.1
+mtd_actuation_seconds_count{result="error"} 1
+mtd_actuation_seconds_bucket{result="success",le="0.5"} 1
+mtd_actuation_seconds_bucket{result="success",le="1"} 2
+mtd_actuation_seconds_bucket{result="success",le="2.5"} 2
+mtd_actuation_seconds_bucket{result="success",le="+Inf"} 3
+mtd_actuation_seconds_sum{result="success"} 11.2
+mtd_actuation_seconds_count{result="success"} 3
+`,
+		},
+		{
+			name: "le formatting",
+			record: func(r *Registry) {
+				h := r.Histogram("mtd_latency_seconds", "Latency.", []float64{0.005, 1e-9, 120, 1e21})
+				h.Observe(200)
+			},
+			want: `# HELP mtd_latency_seconds Latency.
+# TYPE mtd_latency_seconds histogram
+mtd_latency_seconds_bucket{le="1e-09"} 0
+mtd_latency_seconds_bucket{le="0.005"} 0
+mtd_latency_seconds_bucket{le="120"} 0
+mtd_latency_seconds_bucket{le="1e+21"} 1
+mtd_latency_seconds_bucket{le="+Inf"} 1
+mtd_latency_seconds_sum 200
+mtd_latency_seconds_count 1
+`,
+		},
+		{
+			name: "escaping",
+			record: func(r *Registry) {
+				g := r.Gauge("mtd_info", "Path C:\\mtd\nsecond \"line\".", "value")
+				g.Set(math.Inf(1), "a \"quoted\" C:\\path\nnext")
+			},
+			want: `# HELP mtd_info Path C:\\mtd\nsecond "line".
+# TYPE mtd_info gauge
+mtd_info{value="a \"quoted\" C:\\path\nnext"} +Inf
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.record(r)
+			if got := output(t, r); got != tt.want {
+				t.Errorf("WriteTo wrote:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryOrder(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("b", "Second.").Set(2)
+	r.Counter("a", "First.")
+	want := "# HELP b Second.\n# TYPE b gauge\nb 2\n# HELP a First.\n# TYPE a counter\na 0\n"
+	if got := output(t, r); got != want {
+		t.Errorf("WriteTo wrote:\n%s\nwant the registration order:\n%s", got, want)
+	}
+}
+
+// panics reports whether f panics
+func panics(f func()) (panicked bool) {
+	defer func() { panicked = recover() != nil }()
+	f()
+	return false
+}
+
+func TestPanics(t *testing.T) {
+	r := NewRegistry()
+	counter := r.Counter("mtd_moves_total", "Moves.", "result")
+	tests := []struct {
+		name string
+		f    func()
+	}{
+		{name: "duplicate registration", f: func() { r.Gauge("mtd_moves_total", "Moves again.") }},
+		{name: "duplicate histogram", f: func() { r.Histogram("mtd_moves_total", "Moves again.", nil) }},
+		{name: "missing label value", f: func() { counter.Inc() }},
+		{name: "extra label value", f: func() { counter.Inc("success", "extra") }},
+		{name: "decreasing counter", f: func() { counter.Add(-1, "success") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !panics(tt.f) {
+				t.Errorf("%s did not panic", tt.name)
+			}
+		})
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("mtd_moves_total", "Moves.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("GET /metrics = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "mtd_moves_total 1\n") {
+		t.Errorf("GET /metrics body:\n%s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /metrics = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
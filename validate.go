@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"mtd-system/mtd"
+	"strings"
+)
+
+// validateMetrics reports the extra metrics of a metrics file that are not registered
+func validateMetrics(metrics mtd.Metrics) error {
+	var problems []string
+	for _, name := range sortedKeys(metrics.Extra) {
+		if _, ok := mtd.LookupMetric(name); !ok || mtd.IsBuiltinMetric(name) {
+			problems = append(problems, fmt.Sprintf("extra.%s is not in metrics.definitions", name))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// validatePolicies reports the invalid policies and the actions that name configurations that do not exist
+func validatePolicies(policies []mtd.Policy, config mtd.Config) error {
+	var errs []error
+	for i, policy := range policies {
+		var problems []string
+		if err := policy.Validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+		actions := policy.RecommendedActions
+		if actions.SwitchOS != "" && !contains(config.OSes, actions.SwitchOS) {
+			problems = append(problems, fmt.Sprintf("recommended_actions.switch_os %q is not a configured os", actions.SwitchOS))
+		}
+		if actions.SwitchLanguage != "" && !contains(config.Languages, actions.SwitchLanguage) {
+			problems = append(problems, fmt.Sprintf("recommended_actions.switch_language %q is not a configured language", actions.SwitchLanguage))
+		}
+		if actions.SwitchFormat != "" && !contains(config.Formats, actions.SwitchFormat) {
+			problems = append(problems, fmt.Sprintf("recommended_actions.switch_format %q is not a configured format", actions.SwitchFormat))
+		}
+		if len(problems) > 0 {
+			errs = append(errs, fmt.Errorf("policy #%d %q: %s", i, policy.PolicyName, strings.Join(problems, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runValidateConfig runs the `validate-config` subcommand, which checks the controller config and
+// every file it names, and reports all problems at once
+func runValidateConfig(ctx context.Context, args []string) error {
+	var o options
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	o.decisionFlags(fs)
+	o.auditFlags(fs)
+	o.actuatorFlags(fs)
+	o.daemonFlags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	config := o.config
+
+	var errs []error
+	if err := config.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", o.configFile, err))
+	}
+	// Invalid definitions were reported above, the valid ones are needed to check the files below
+	var definitions []mtd.MetricDefinition
+	for _, def := range config.Metrics.Definitions {
+		if def.Validate() == nil && !mtd.IsBuiltinMetric(def.Name) {
+			definitions = append(definitions, def)
+		}
+	}
+	registerMetrics(definitions)
+
+	metrics, err := mtd.LoadMetrics(config.Metrics.File)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", config.Metrics.File, err))
+	} else if err := validateMetrics(metrics); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", config.Metrics.File, err))
+	}
+
+	// The knowledge file feeds both the file knowledge base and kb ingest
+	policies, err := mtd.LoadPolicies(config.Knowledge.File)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", config.Knowledge.File, err))
+	} else if err := validatePolicies(policies, config.MoveSpace); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", config.Knowledge.File, err))
+	}
+
+	if _, err := o.newMetricsSource(); err != nil {
+		errs = append(errs, fmt.Errorf("metrics source: %w", err))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	log.Printf("Configuration is valid")
+	return nil
+}